@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urbpeti/actions-automatic-cancel/lib"
+)
+
+const usage = `Usage:
+  cancelctl list  --repo org/name [--branch x] [--output table|json]
+  cancelctl sweep --repo org/name [--branch x] [--dry-run] [--output table|json]`
+
+// row is a decision together with what happened to the run
+type row struct {
+	lib.Decision
+	Action string `json:"action"`
+}
+
+type cli struct {
+	stdout  io.Writer
+	stderr  io.Writer
+	makeAPI func(repository string) (lib.Provider, error)
+}
+
+type options struct {
+	repository string
+	branch     string
+	dryRun     bool
+	output     string
+}
+
+func parseOptions(command string, args []string, stderr io.Writer) (options, error) {
+	opts := options{}
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&opts.repository, "repo", "", "repository as org/name")
+	flags.StringVar(&opts.branch, "branch", "", "only consider runs of this branch")
+	flags.StringVar(&opts.output, "output", "table", "output format: table or json")
+	if command == "sweep" {
+		flags.BoolVar(&opts.dryRun, "dry-run", false, "print the decisions without cancelling")
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		return opts, err
+	}
+	if opts.repository == "" {
+		return opts, fmt.Errorf("Missing --repo")
+	}
+	if opts.output != "table" && opts.output != "json" {
+		return opts, fmt.Errorf("Bad output format: %s", opts.output)
+	}
+
+	return opts, nil
+}
+
+func listRuns(api lib.Provider, branch string) ([]lib.WorkflowRun, error) {
+	if branch == "" {
+		return api.ListWorkflows()
+	}
+
+	return lib.ListBranchWorkflows(api, branch)
+}
+
+// cancelRecorder remembers the outcome of the cancels Policy.Apply makes
+type cancelRecorder struct {
+	lib.Provider
+	errs map[int64]error
+}
+
+func (api *cancelRecorder) CancelRun(run lib.WorkflowRun) error {
+	err := api.Provider.CancelRun(run)
+	api.errs[run.ID] = err
+	return err
+}
+
+func (c *cli) run(args []string) error {
+	if len(args) < 1 || (args[0] != "list" && args[0] != "sweep") {
+		return errors.New(usage)
+	}
+	command := args[0]
+
+	opts, err := parseOptions(command, args[1:], c.stderr)
+	if err != nil {
+		return err
+	}
+
+	api, err := c.makeAPI(opts.repository)
+	if err != nil {
+		return err
+	}
+
+	runs, err := listRuns(api, opts.branch)
+	if err != nil {
+		return err
+	}
+
+	policy, err := lib.MakePolicyFromEnv()
+	if err != nil {
+		return err
+	}
+	policy = policy.WithLogger(lib.MakeLogger(c.stderr, lib.LevelWarn))
+	decisions := policy.Decide(api, runs)
+	sort.SliceStable(decisions, func(i, j int) bool {
+		return decisions[i].Key < decisions[j].Key
+	})
+
+	var applyErr error
+	var cancels *cancelRecorder
+	if command == "sweep" && !opts.dryRun {
+		cancels = &cancelRecorder{Provider: api, errs: make(map[int64]error)}
+		applyErr = policy.Apply(cancels, decisions)
+	}
+
+	rows := make([]row, 0, len(decisions))
+	for _, decision := range decisions {
+		rows = append(rows, row{Decision: decision, Action: action(decision, cancels)})
+	}
+
+	err = c.print(opts.output, rows)
+	if err != nil {
+		return err
+	}
+	return applyErr
+}
+
+// action tells what happened to the run, cancels is nil when nothing was cancelled
+func action(decision lib.Decision, cancels *cancelRecorder) string {
+	if !decision.Cancel {
+		return "keep"
+	}
+	if cancels == nil {
+		return "cancel"
+	}
+
+	err := cancels.errs[decision.Run.ID]
+	if err != nil {
+		return "cancel failed: " + err.Error()
+	}
+
+	return "cancelled"
+}
+
+func (c *cli) print(output string, rows []row) error {
+	if output == "json" {
+		encoder := json.NewEncoder(c.stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(rows)
+	}
+
+	return c.printTable(rows)
+}
+
+func (c *cli) printTable(rows []row) error {
+	writer := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "KEY\tRUN\tSTATUS\tCREATED\tACTION\tREASON")
+	for _, r := range rows {
+		fmt.Fprintf(writer, "%s\t%d\t%s\t%s\t%s\t%s\n",
+			r.Key, r.Run.ID, r.Run.Status, r.Run.CreatedAt.Format(time.RFC3339), r.Action, r.Reason)
+	}
+
+	return writer.Flush()
+}
+
+func main() {
+	c := cli{
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+		makeAPI: func(repository string) (lib.Provider, error) {
+			return lib.ProviderFromEnv(repository, nil, nil)
+		},
+	}
+
+	err := c.run(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
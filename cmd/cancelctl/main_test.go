@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/urbpeti/actions-automatic-cancel/lib"
+)
+
+type MockGithubAPI struct {
+	cancels  []lib.WorkflowRun
+	branches []string
+	failing  map[int64]bool
+}
+
+func (api *MockGithubAPI) ListWorkflows() ([]lib.WorkflowRun, error) {
+	return []lib.WorkflowRun{
+		lib.WorkflowRun{ID: 1, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC), HeadBranch: "master", Status: "in_progress"},
+		lib.WorkflowRun{ID: 2, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 1, time.UTC), HeadBranch: "master", Status: "in_progress"},
+		lib.WorkflowRun{ID: 3, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC), HeadBranch: "feature", Status: "queued"},
+		lib.WorkflowRun{ID: 4, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 2, time.UTC), HeadBranch: "feature", Status: "completed"},
+	}, nil
+}
+
+func (api *MockGithubAPI) ListBranchWorkflows(branch string) ([]lib.WorkflowRun, error) {
+	api.branches = append(api.branches, branch)
+	runs, _ := api.ListWorkflows()
+	var branchRuns []lib.WorkflowRun
+	for _, run := range runs {
+		if run.HeadBranch == branch {
+			branchRuns = append(branchRuns, run)
+		}
+	}
+
+	return branchRuns, nil
+}
+
+func (api *MockGithubAPI) CancelRun(run lib.WorkflowRun) error {
+	if api.failing[run.ID] {
+		return fmt.Errorf("Server error")
+	}
+	api.cancels = append(api.cancels, run)
+	return nil
+}
+
+func (api *MockGithubAPI) GroupKey(run lib.WorkflowRun) string {
+	return run.HeadBranch
+}
+
+func makeTestCli(api *MockGithubAPI) (*cli, *bytes.Buffer) {
+	stdout := &bytes.Buffer{}
+	return &cli{
+		stdout:  stdout,
+		stderr:  &bytes.Buffer{},
+		makeAPI: func(repository string) (lib.Provider, error) { return api, nil },
+	}, stdout
+}
+
+func TestRun(t *testing.T) {
+	t.Run("Unknown command", func(t *testing.T) {
+		c, _ := makeTestCli(&MockGithubAPI{})
+
+		err := c.run([]string{"delete"})
+		if err == nil || !strings.Contains(err.Error(), "Usage") {
+			t.Errorf("Bad error: %v", err)
+		}
+	})
+
+	t.Run("Missing repo", func(t *testing.T) {
+		c, _ := makeTestCli(&MockGithubAPI{})
+
+		err := c.run([]string{"list"})
+		if err == nil || err.Error() != "Missing --repo" {
+			t.Errorf("Bad error: %v", err)
+		}
+	})
+
+	t.Run("List does not cancel", func(t *testing.T) {
+		api := &MockGithubAPI{}
+		c, stdout := makeTestCli(api)
+
+		err := c.run([]string{"list", "--repo", "org/repo"})
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		if len(api.cancels) != 0 {
+			t.Errorf("List should not cancel runs")
+		}
+		lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+		if len(lines) != 4 {
+			t.Fatalf("Expected header and 3 rows, got:\n%s", stdout.String())
+		}
+		if !strings.HasPrefix(lines[1], "feature") || !strings.Contains(lines[3], "superseded by run 2") {
+			t.Errorf("Bad table:\n%s", stdout.String())
+		}
+	})
+
+	t.Run("Sweep dry run as json", func(t *testing.T) {
+		api := &MockGithubAPI{}
+		c, stdout := makeTestCli(api)
+
+		err := c.run([]string{"sweep", "--repo", "org/repo", "--dry-run", "--output", "json"})
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		if len(api.cancels) != 0 {
+			t.Errorf("Dry run should not cancel runs")
+		}
+		var rows []row
+		err = json.Unmarshal(stdout.Bytes(), &rows)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if len(rows) != 3 || rows[2].Run.ID != 1 || rows[2].Action != "cancel" {
+			t.Errorf("Bad rows: %s", stdout.String())
+		}
+	})
+
+	t.Run("Sweep cancels superseded runs of the branch", func(t *testing.T) {
+		api := &MockGithubAPI{}
+		c, _ := makeTestCli(api)
+
+		err := c.run([]string{"sweep", "--repo", "org/repo", "--branch", "master"})
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		if len(api.cancels) != 1 || api.cancels[0].ID != 1 {
+			t.Errorf("Bad cancels: %v", api.cancels)
+		}
+		if len(api.branches) != 1 || api.branches[0] != "master" {
+			t.Errorf("Runs of the branch should be listed: %v", api.branches)
+		}
+	})
+
+	t.Run("Sweep fails when cancels fail", func(t *testing.T) {
+		api := &MockGithubAPI{failing: map[int64]bool{1: true}}
+		c, stdout := makeTestCli(api)
+
+		err := c.run([]string{"sweep", "--repo", "org/repo", "--output", "json"})
+		if err == nil || err.Error() != "Cancelling 1 runs failed: Server error" {
+			t.Errorf("Bad error: %v", err)
+		}
+		var rows []row
+		json.Unmarshal(stdout.Bytes(), &rows)
+		if len(rows) != 3 || rows[2].Action != "cancel failed: Server error" {
+			t.Errorf("Bad rows: %s", stdout.String())
+		}
+	})
+}
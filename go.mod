@@ -3,7 +3,6 @@ module github.com/urbpeti/actions-automatic-cancel
 go 1.13
 
 require (
-	github.com/aws/aws-lambda-go v1.14.0
-	github.com/golang/protobuf v1.3.4 // indirect
+	github.com/aws/aws-lambda-go v1.34.1
 	gopkg.in/h2non/gock.v1 v1.0.15
 )
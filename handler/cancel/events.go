@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+const (
+	payloadFormatRESTAPI = iota
+	payloadFormatHTTPAPI
+	payloadFormatFunctionURL
+)
+
+const functionURLDomainMarker = ".lambda-url."
+
+// payloadProbe holds the fields needed to tell the payload formats apart
+type payloadProbe struct {
+	Version        string `json:"version"`
+	RequestContext struct {
+		DomainName string `json:"domainName"`
+	} `json:"requestContext"`
+}
+
+func detectPayloadFormat(payload []byte) (int, error) {
+	probe := payloadProbe{}
+	err := json.Unmarshal(payload, &probe)
+	if err != nil {
+		return payloadFormatRESTAPI, err
+	}
+
+	if probe.Version != "2.0" {
+		return payloadFormatRESTAPI, nil
+	}
+	if strings.Contains(probe.RequestContext.DomainName, functionURLDomainMarker) {
+		return payloadFormatFunctionURL, nil
+	}
+
+	return payloadFormatHTTPAPI, nil
+}
+
+func decodeBody(body string, isBase64Encoded bool) (string, error) {
+	if !isBase64Encoded {
+		return body, nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return "", err
+	}
+
+	return string(decoded), nil
+}
+
+func makeProxyRequest(headers map[string]string, body string, isBase64Encoded bool) (events.APIGatewayProxyRequest, error) {
+	decoded, err := decodeBody(body, isBase64Encoded)
+	if err != nil {
+		return events.APIGatewayProxyRequest{}, err
+	}
+
+	return events.APIGatewayProxyRequest{Headers: headers, Body: decoded}, nil
+}
+
+// HandleEvent detects the payload format of the invocation and passes it to HandleRequest
+func (canceler *AutomaticCancel) HandleEvent(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+	format, err := detectPayloadFormat(payload)
+	if err != nil {
+		return nil, err
+	}
+	requestID := ""
+	if lambdaContext, ok := lambdacontext.FromContext(ctx); ok {
+		requestID = lambdaContext.AwsRequestID
+	}
+
+	switch format {
+	case payloadFormatFunctionURL:
+		event := events.LambdaFunctionURLRequest{}
+		err = json.Unmarshal(payload, &event)
+		if err != nil {
+			return nil, err
+		}
+		req, err := makeProxyRequest(event.Headers, event.Body, event.IsBase64Encoded)
+		if err != nil {
+			return events.LambdaFunctionURLResponse{StatusCode: http.StatusBadRequest, Body: err.Error()}, nil
+		}
+		res, err := canceler.handle(requestID, req)
+		return events.LambdaFunctionURLResponse{StatusCode: res.StatusCode, Headers: res.Headers, Body: res.Body}, err
+
+	case payloadFormatHTTPAPI:
+		event := events.APIGatewayV2HTTPRequest{}
+		err = json.Unmarshal(payload, &event)
+		if err != nil {
+			return nil, err
+		}
+		req, err := makeProxyRequest(event.Headers, event.Body, event.IsBase64Encoded)
+		if err != nil {
+			return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusBadRequest, Body: err.Error()}, nil
+		}
+		res, err := canceler.handle(requestID, req)
+		return events.APIGatewayV2HTTPResponse{StatusCode: res.StatusCode, Headers: res.Headers, Body: res.Body}, err
+	}
+
+	event := events.APIGatewayProxyRequest{}
+	err = json.Unmarshal(payload, &event)
+	if err != nil {
+		return nil, err
+	}
+	req, err := makeProxyRequest(event.Headers, event.Body, event.IsBase64Encoded)
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest, Body: err.Error()}, nil
+	}
+
+	return canceler.handle(requestID, req)
+}
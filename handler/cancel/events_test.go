@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/urbpeti/actions-automatic-cancel/lib"
+	"github.com/urbpeti/actions-automatic-cancel/utils"
+)
+
+func TestHandleEvent(t *testing.T) {
+	canceler := AutomaticCancel{
+		Provider: &MockGithubAPI{
+			MockListWorkflows: func() ([]lib.WorkflowRun, error) { return []lib.WorkflowRun{}, nil },
+			MockCancelRun:     func(lib.WorkflowRun) error { return nil },
+		},
+		WebHookSecret: "secret",
+	}
+
+	t.Run("REST API payload", func(t *testing.T) {
+		payload, _ := json.Marshal(events.APIGatewayProxyRequest{
+			HTTPMethod: "POST",
+			Body:       "dummy",
+			Headers:    map[string]string{"X-Hub-Signature": "sha1=2486c8590c396f876a46fb541e57fb3f9f276052"},
+		})
+
+		res, err := canceler.HandleEvent(context.Background(), payload)
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		proxyRes, ok := res.(events.APIGatewayProxyResponse)
+		if !ok {
+			t.Fatalf("Bad response type %T", res)
+		}
+		if proxyRes.StatusCode != http.StatusOK {
+			t.Errorf("Expected status: %d, actual: %d", http.StatusOK, proxyRes.StatusCode)
+		}
+	})
+
+	t.Run("HTTP API payload with base64 body", func(t *testing.T) {
+		payload, _ := json.Marshal(events.APIGatewayV2HTTPRequest{
+			Version:         "2.0",
+			Body:            base64.StdEncoding.EncodeToString([]byte("dummy")),
+			IsBase64Encoded: true,
+			Headers:         map[string]string{"x-hub-signature": "sha1=2486c8590c396f876a46fb541e57fb3f9f276052"},
+			RequestContext:  events.APIGatewayV2HTTPRequestContext{DomainName: "abc.execute-api.eu-west-1.amazonaws.com"},
+		})
+
+		res, err := canceler.HandleEvent(context.Background(), payload)
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		httpRes, ok := res.(events.APIGatewayV2HTTPResponse)
+		if !ok {
+			t.Fatalf("Bad response type %T", res)
+		}
+		if httpRes.StatusCode != http.StatusOK {
+			t.Errorf("Expected status: %d, actual: %d", http.StatusOK, httpRes.StatusCode)
+		}
+	})
+
+	t.Run("Function URL payload with base64 body", func(t *testing.T) {
+		payload, _ := json.Marshal(events.LambdaFunctionURLRequest{
+			Version:         "2.0",
+			Body:            base64.StdEncoding.EncodeToString([]byte("dummy")),
+			IsBase64Encoded: true,
+			Headers:         map[string]string{"x-hub-signature": "sha1=2486c8590c396f876a46fb541e57fb3f9f276052"},
+			RequestContext:  events.LambdaFunctionURLRequestContext{DomainName: "abc.lambda-url.eu-west-1.on.aws"},
+		})
+
+		res, err := canceler.HandleEvent(context.Background(), payload)
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		urlRes, ok := res.(events.LambdaFunctionURLResponse)
+		if !ok {
+			t.Fatalf("Bad response type %T", res)
+		}
+		if urlRes.StatusCode != http.StatusOK {
+			t.Errorf("Expected status: %d, actual: %d", http.StatusOK, urlRes.StatusCode)
+		}
+	})
+
+	t.Run("Function URL payload bad signature", func(t *testing.T) {
+		payload, _ := json.Marshal(events.LambdaFunctionURLRequest{
+			Version:        "2.0",
+			Body:           "dummy",
+			Headers:        map[string]string{"x-hub-signature": "sha1=0000000000000000000000000000000000000000"},
+			RequestContext: events.LambdaFunctionURLRequestContext{DomainName: "abc.lambda-url.eu-west-1.on.aws"},
+		})
+
+		res, err := canceler.HandleEvent(context.Background(), payload)
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		urlRes := res.(events.LambdaFunctionURLResponse)
+		if urlRes.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status: %d, actual: %d", http.StatusBadRequest, urlRes.StatusCode)
+		}
+		if urlRes.Body != "Signature missmatch" {
+			t.Errorf("Bad body %s", urlRes.Body)
+		}
+	})
+
+	t.Run("Bad base64 body", func(t *testing.T) {
+		payload, _ := json.Marshal(events.APIGatewayV2HTTPRequest{
+			Version:         "2.0",
+			Body:            "!!!",
+			IsBase64Encoded: true,
+		})
+
+		res, err := canceler.HandleEvent(context.Background(), payload)
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		httpRes := res.(events.APIGatewayV2HTTPResponse)
+		if httpRes.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status: %d, actual: %d", http.StatusBadRequest, httpRes.StatusCode)
+		}
+	})
+}
+
+func TestHandleEventLogs(t *testing.T) {
+	output := &bytes.Buffer{}
+	body := `{"after":"b","repository":{"full_name":"org/repo"}}`
+	canceler := AutomaticCancel{
+		Provider: &MockGithubAPI{
+			MockListWorkflows: func() ([]lib.WorkflowRun, error) {
+				return []lib.WorkflowRun{
+					lib.WorkflowRun{ID: 1, HeadBranch: "master", HeadSHA: "a", Status: "in_progress", CreatedAt: time.Unix(1, 0)},
+					lib.WorkflowRun{ID: 2, HeadBranch: "master", HeadSHA: "b", Status: "in_progress", CreatedAt: time.Unix(2, 0)},
+				}, nil
+			},
+			MockCancelRun: func(lib.WorkflowRun) error { return nil },
+		},
+		WebHookSecret: "secret",
+		Logger:        lib.MakeLogger(output, lib.LevelInfo, "secret"),
+	}
+	payload, _ := json.Marshal(events.APIGatewayProxyRequest{
+		Body: body,
+		Headers: map[string]string{
+			"X-Hub-Signature":   utils.SignPayload("secret", []byte(body)),
+			"X-GitHub-Delivery": "guid-1",
+			"X-GitHub-Event":    "push",
+		},
+	})
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "request-1"})
+
+	_, err := canceler.HandleEvent(ctx, payload)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Bad lines: %s", output.String())
+	}
+	var cancelled map[string]interface{}
+	for _, line := range lines {
+		fields := make(map[string]interface{})
+		json.Unmarshal([]byte(line), &fields)
+		if fields["delivery_id"] != "guid-1" || fields["event"] != "push" || fields["repo"] != "org/repo" || fields["request_id"] != "request-1" {
+			t.Errorf("Missing correlation ids: %s", line)
+		}
+		if fields["action"] == "cancelled" {
+			cancelled = fields
+		}
+	}
+	if cancelled["run_id"] != float64(1) || cancelled["branch"] != "master" || cancelled["reason"] != "superseded by run 2" {
+		t.Errorf("Bad decision line: %v", cancelled)
+	}
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
-	"log"
+	"context"
+	"fmt"
 	"net/http"
 	"os"
-	"sort"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -14,66 +19,425 @@ import (
 
 // AutomaticCancel struct
 type AutomaticCancel struct {
-	GithubAPI     lib.IGithubAPI
+	Provider      lib.Provider
 	WebHookSecret string
+	// WebhookProvider is the provider whose signatures are accepted, like lib.ProviderGitLab, GitHub when empty
+	WebhookProvider string
+	DryRun          bool
+	Recorder        *Recorder
+	Deduplicator    *lib.Deduplicator
+	Policy          *lib.Policy
+	// Logger is used for the lines of every delivery, the default logger is used when nil
+	Logger *lib.Logger
+	// Metrics receives the webhook latency, listed and cancelled runs when not nil
+	Metrics lib.Metrics
 }
 
-func sortRunsByCreatedAtDesc(runs []lib.WorkflowRun) {
-	sort.Slice(runs, func(i, j int) bool {
-		return runs[i].CreatedAt.After(runs[j].CreatedAt)
-	})
+func (canceler *AutomaticCancel) policy() *lib.Policy {
+	if canceler.Policy == nil {
+		return lib.MakeDefaultPolicy()
+	}
+
+	return canceler.Policy
 }
 
 // AutomaticCancel function
 func (canceler *AutomaticCancel) AutomaticCancel(runs []lib.WorkflowRun) error {
-	sortRunsByCreatedAtDesc(runs)
+	_, err := canceler.cancel(runs)
+	return err
+}
 
-	seenBranch := make(map[string]bool)
-	for _, run := range runs {
-		if run.Status == "completed" {
-			continue
-		}
+func (canceler *AutomaticCancel) cancel(runs []lib.WorkflowRun) ([]lib.Decision, error) {
+	return canceler.cancelWith(canceler.policy(), runs)
+}
 
-		branch := run.HeadBranch
+func (canceler *AutomaticCancel) cancelWith(policy *lib.Policy, runs []lib.WorkflowRun) ([]lib.Decision, error) {
+	decisions := policy.Decide(canceler.Provider, runs)
+	return decisions, canceler.apply(policy, decisions)
+}
 
-		if _, ok := seenBranch[branch]; ok {
-			err := canceler.GithubAPI.CancelRun(run)
-			if err != nil {
-				log.Println(err.Error())
-				continue
+func (canceler *AutomaticCancel) apply(policy *lib.Policy, decisions []lib.Decision) error {
+	if canceler.DryRun {
+		logger := policy.Logger
+		if logger == nil {
+			logger = lib.DefaultLogger()
+		}
+		for _, decision := range decisions {
+			action := "keep"
+			if decision.Cancel {
+				action = "dry run cancel"
 			}
-		} else {
-			seenBranch[branch] = true
+			lib.LogDecision(logger, decision, action, nil)
+		}
+		return nil
+	}
+
+	return policy.Apply(canceler.Provider, decisions)
+}
+
+func (canceler *AutomaticCancel) logger() *lib.Logger {
+	if canceler.Logger == nil {
+		return lib.DefaultLogger()
+	}
+
+	return canceler.Logger
+}
+
+// firstHeader returns the first of the headers sent, the forges name their headers differently
+func firstHeader(headers map[string]string, names ...string) string {
+	for _, name := range names {
+		if value, ok := utils.GetHeader(headers, name); ok {
+			return value
 		}
 	}
 
-	return nil
+	return ""
+}
+
+// requestLogger adds the ids correlating the lines of a delivery
+func (canceler *AutomaticCancel) requestLogger(requestID string, req events.APIGatewayProxyRequest) *lib.Logger {
+	payload, _ := lib.ParseWebhookPayload(req.Body)
+	fields := lib.Fields{
+		"delivery_id": firstHeader(req.Headers, "X-GitHub-Delivery", "X-Gitea-Delivery", "X-Gitlab-Event-UUID"),
+		"event":       firstHeader(req.Headers, "X-GitHub-Event", "X-Gitea-Event", "X-Gitlab-Event"),
+		"repo":        payload.Repository.FullName,
+	}
+	if requestID != "" {
+		fields["request_id"] = requestID
+	}
+
+	return canceler.logger().With(fields)
 }
 
 // HandleRequest cancels running workflows
 func (canceler *AutomaticCancel) HandleRequest(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	err := utils.VerifyGithubWebhookRequest(req, canceler.WebHookSecret)
+	return canceler.handle("", req)
+}
+
+// handle processes the request of the Lambda invocation and logs the outcome
+func (canceler *AutomaticCancel) handle(requestID string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	start := time.Now()
+	logger := canceler.requestLogger(requestID, req)
+	res, decisions, err := canceler.process(logger, req)
+	if canceler.Metrics != nil {
+		dimensions := lib.Dimensions{
+			"Event":   firstHeader(req.Headers, "X-GitHub-Event", "X-Gitea-Event", "X-Gitlab-Event"),
+			"Outcome": webhookOutcome(res, decisions, err),
+		}
+		canceler.Metrics.Put(lib.MetricWebhookLatency, lib.Milliseconds(time.Since(start)), lib.UnitMilliseconds, dimensions)
+	}
 	if err != nil {
-		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest, Body: err.Error()}, nil
+		logger.Error("Handling delivery failed", lib.Fields{"status": res.StatusCode, "error": err})
+		return res, err
 	}
 
-	workflows, err := canceler.GithubAPI.ListWorkflows()
+	logger.Info("Delivery handled", lib.Fields{"status": res.StatusCode, "body": res.Body, "decisions": len(decisions)})
+	return res, err
+}
+
+// webhookOutcome sorts the response of a delivery into handled, skipped, rejected or error
+func webhookOutcome(res events.APIGatewayProxyResponse, decisions []lib.Decision, err error) string {
+	switch {
+	case err != nil || res.StatusCode >= http.StatusInternalServerError:
+		return "error"
+	case res.StatusCode >= http.StatusBadRequest:
+		return "rejected"
+	case decisions == nil && res.Body != "":
+		// Duplicates and protected branches are answered with the reason
+		return "skipped"
+	}
+
+	return "handled"
+}
+
+// process handles the request and also returns the decisions for reporting
+func (canceler *AutomaticCancel) process(logger *lib.Logger, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, []lib.Decision, error) {
+	err := utils.VerifyWebhookRequest(req, canceler.WebHookSecret, canceler.WebhookProvider)
 	if err != nil {
-		return events.APIGatewayProxyResponse{}, err
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest, Body: err.Error()}, nil, nil
+	}
+
+	if skip, reason := canceler.isDuplicate(logger, req); skip {
+		return canceler.answer(logger, req, reason), nil, nil
 	}
 
-	err = canceler.AutomaticCancel(workflows)
+	event, _ := utils.GetHeader(req.Headers, "X-GitHub-Event")
+	payload, _ := lib.ParseWebhookPayload(req.Body)
+	metrics := lib.WithDimensions(canceler.Metrics, lib.Dimensions{"Repository": payload.Repository.FullName})
+	policy := canceler.policy().WithLogger(logger).WithMetrics(metrics)
+	if obsolete, ok := findObsoleteRuns(event, payload); ok {
+		return canceler.cancelObsolete(policy, req, obsolete)
+	}
+
+	workflows, err := canceler.Provider.ListWorkflows()
+	if err != nil {
+		canceler.record(logger, req, nil, nil)
+		canceler.release(logger, req)
+		return events.APIGatewayProxyResponse{}, nil, err
+	}
+	if reporter, ok := canceler.Provider.(lib.ETagStatsReporter); ok {
+		logETagStats(logger, reporter.ETagStats())
+	}
+	if metrics != nil {
+		metrics.Put(lib.MetricRunsListed, float64(len(workflows)), lib.UnitCount, nil)
+	}
+
+	decisions, err := canceler.cancelWith(policy, workflows)
+	canceler.record(logger, req, workflows, decisions)
+	if err != nil {
+		// Failed cancels are logged with their decision, a redelivery tries them again
+		canceler.release(logger, req)
+	}
+
+	return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, decisions, nil
+}
+
+// uncoalescedEvents are handled even when another event of the commit was just handled
+var uncoalescedEvents = []string{
+	// The push often arrives before its runs exist, the runs are only seen by the workflow_run events
+	"workflow_run",
+	// A run superseded while its protected jobs were running is only cancelled when the next job event lists it again
+	"workflow_job",
+}
+
+// dedupeKeys returns the delivery id, repository and commit the delivery is deduplicated by
+func dedupeKeys(req events.APIGatewayProxyRequest) (string, string, string) {
+	deliveryID, _ := utils.GetHeader(req.Headers, "X-GitHub-Delivery")
+	event, _ := utils.GetHeader(req.Headers, "X-GitHub-Event")
+	// Bodies which are not JSON can still be deduplicated by their delivery id
+	payload, _ := lib.ParseWebhookPayload(req.Body)
+	headSHA := payload.HeadSHA()
+	if _, ok := findObsoleteRuns(event, payload); ok {
+		// The commit was already seen, but the event still makes its runs obsolete
+		headSHA = ""
+	}
+	for _, uncoalesced := range uncoalescedEvents {
+		if event == uncoalesced {
+			headSHA = ""
+		}
+	}
+
+	return deliveryID, payload.Repository.FullName, headSHA
+}
+
+func (canceler *AutomaticCancel) isDuplicate(logger *lib.Logger, req events.APIGatewayProxyRequest) (bool, string) {
+	if canceler.Deduplicator == nil {
+		return false, ""
+	}
+
+	deliveryID, repository, headSHA := dedupeKeys(req)
+	skip, reason, err := canceler.Deduplicator.ShouldSkip(deliveryID, repository, headSHA)
 	if err != nil {
-		return events.APIGatewayProxyResponse{StatusCode: http.StatusInternalServerError}, err
+		logger.Warn("Dedupe store failed", lib.Fields{"error": err})
+		return false, ""
 	}
 
-	return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, nil
+	return skip, reason
+}
+
+// release lets a redelivery of the failed delivery through
+func (canceler *AutomaticCancel) release(logger *lib.Logger, req events.APIGatewayProxyRequest) {
+	if canceler.Deduplicator == nil {
+		return
+	}
+
+	deliveryID, repository, headSHA := dedupeKeys(req)
+	err := canceler.Deduplicator.Release(deliveryID, repository, headSHA)
+	if err != nil {
+		logger.Warn("Releasing the delivery failed", lib.Fields{"error": err})
+	}
+}
+
+func (canceler *AutomaticCancel) record(logger *lib.Logger, req events.APIGatewayProxyRequest, runs []lib.WorkflowRun, decisions []lib.Decision) {
+	if canceler.Recorder == nil {
+		return
+	}
+
+	err := canceler.Recorder.Record(req, runs, decisions)
+	if err != nil {
+		logger.Warn("Recording delivery failed", lib.Fields{"error": err})
+	}
+}
+
+// answer records a verified delivery answered with the reason without listing runs
+func (canceler *AutomaticCancel) answer(logger *lib.Logger, req events.APIGatewayProxyRequest, reason string) events.APIGatewayProxyResponse {
+	if canceler.Recorder != nil {
+		err := canceler.Recorder.RecordResponse(req, reason)
+		if err != nil {
+			logger.Warn("Recording delivery failed", lib.Fields{"error": err})
+		}
+	}
+
+	return events.APIGatewayProxyResponse{StatusCode: http.StatusOK, Body: reason}
+}
+
+func logETagStats(logger *lib.Logger, stats lib.ETagStats) {
+	if stats.Hits+stats.Misses > 0 {
+		logger.Info("ETag cache", lib.Fields{"hits": stats.Hits, "misses": stats.Misses, "hit_rate": stats.HitRate()})
+	}
+}
+
+// metricsFromEnv creates the EMF sink of METRICS_NAMESPACE unless METRICS is none
+func metricsFromEnv() lib.Metrics {
+	if os.Getenv("METRICS") == "none" {
+		return nil
+	}
+
+	namespace := os.Getenv("METRICS_NAMESPACE")
+	if namespace == "" {
+		namespace = "ActionsAutomaticCancel"
+	}
+	return lib.MakeEMFMetrics(os.Stdout, namespace)
+}
+
+// etagCacheFromEnv creates the cache of ETAG_CACHE_SIZE entries, 0 disables it
+func etagCacheFromEnv() *lib.ETagCache {
+	size, err := strconv.Atoi(os.Getenv("ETAG_CACHE_SIZE"))
+	if err != nil {
+		size = 100
+	}
+	if size <= 0 {
+		return nil
+	}
+
+	return lib.MakeETagCache(size)
+}
+
+// pollerFromEnv creates the poller of POLL_INTERVAL, POLL_JITTER and POLL_MAX_BACKOFF
+func pollerFromEnv() (*lib.Poller, error) {
+	interval, err := lib.DurationFromEnv("POLL_INTERVAL", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("Bad POLL_INTERVAL: %s is not a positive duration", os.Getenv("POLL_INTERVAL"))
+	}
+	jitter, err := lib.DurationFromEnv("POLL_JITTER", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	maxBackoff, err := lib.DurationFromEnv("POLL_MAX_BACKOFF", 15*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	return lib.MakePoller(interval, jitter, maxBackoff), nil
+}
+
+func runPoller() error {
+	poller, err := pollerFromEnv()
+	if err != nil {
+		return err
+	}
+	policy, err := lib.MakePolicyFromEnv()
+	if err != nil {
+		return err
+	}
+	poller.Policy = policy
+	poller.Policy.Metrics = metricsFromEnv()
+	etags := etagCacheFromEnv()
+	for _, repository := range strings.Split(os.Getenv("POLL_REPOS"), ",") {
+		repository = strings.TrimSpace(repository)
+		if repository == "" {
+			continue
+		}
+		provider, err := lib.ProviderFromEnv(repository, etags, poller.Policy.Metrics)
+		if err != nil {
+			return err
+		}
+		poller.AddRepository(repository, provider)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		<-signals
+		lib.DefaultLogger().Info("Shutting down poller", nil)
+		if etags != nil {
+			logETagStats(lib.DefaultLogger(), etags.Stats())
+		}
+		cancel()
+	}()
+
+	return poller.Run(ctx)
+}
+
+// makeCanceler creates the canceler of the environment sending its metrics to metrics
+func makeCanceler(metrics lib.Metrics) (*AutomaticCancel, error) {
+	policy, err := lib.MakePolicyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	provider, err := lib.ProviderFromEnv(lib.RepositoryFromEnv(), etagCacheFromEnv(), metrics)
+	if err != nil {
+		return nil, err
+	}
+	ttl, err := lib.DurationFromEnv("DEDUPE_TTL", 5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	window, err := lib.DurationFromEnv("COALESCE_WINDOW", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	canceler := &AutomaticCancel{
+		Metrics:         metrics,
+		Provider:        provider,
+		WebHookSecret:   os.Getenv("WEBHOOK_SECRET"),
+		WebhookProvider: lib.ProviderNameFromEnv(),
+		Policy:          policy,
+		Deduplicator:    lib.MakeDeduplicator(ttl, window),
+	}
+	if path := os.Getenv("CAPTURE_FILE"); path != "" {
+		recorder, err := OpenRecorder(path, canceler.WebHookSecret, os.Getenv("GITHUB_TOKEN"))
+		if err != nil {
+			return nil, err
+		}
+		canceler.Recorder = recorder
+	}
+
+	return canceler, nil
+}
+
+func fatal(err error) {
+	lib.DefaultLogger().Error("Exiting", lib.Fields{"error": err})
+	os.Exit(1)
 }
 
 func main() {
-	canceler := AutomaticCancel{
-		GithubAPI:     lib.MakeGithubAPI(),
-		WebHookSecret: os.Getenv("WEBHOOK_SECRET"),
+	logger, err := lib.MakeLoggerFromEnv(os.Stderr)
+	if err != nil {
+		fatal(err)
+	}
+	lib.SetDefaultLogger(logger)
+	if len(os.Args) > 1 && os.Args[1] == "poll" {
+		err := runPoller()
+		if err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		err := runServer()
+		if err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		err := runReplay(os.Args[2:], os.Stdout)
+		if err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	canceler, err := makeCanceler(metricsFromEnv())
+	if err != nil {
+		fatal(err)
 	}
-	lambda.Start(canceler.HandleRequest)
+	lambda.Start(canceler.HandleEvent)
 }
@@ -1,15 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/urbpeti/actions-automatic-cancel/lib"
+	"github.com/urbpeti/actions-automatic-cancel/utils"
 	"gopkg.in/h2non/gock.v1"
 )
 
@@ -24,10 +27,13 @@ func (api *MockGithubAPI) ListWorkflows() ([]lib.WorkflowRun, error) {
 func (api *MockGithubAPI) CancelRun(run lib.WorkflowRun) error {
 	return api.MockCancelRun(run)
 }
+func (api *MockGithubAPI) GroupKey(run lib.WorkflowRun) string {
+	return run.HeadBranch
+}
 
 func TestHandleRequest(t *testing.T) {
 	canceler := AutomaticCancel{
-		GithubAPI:     &MockGithubAPI{},
+		Provider:      &MockGithubAPI{},
 		WebHookSecret: "secret",
 	}
 
@@ -68,7 +74,7 @@ func TestHandleRequest(t *testing.T) {
 	})
 
 	t.Run("List workflows err should return internal server error", func(t *testing.T) {
-		canceler.GithubAPI = &MockGithubAPI{MockListWorkflows: func() ([]lib.WorkflowRun, error) { return []lib.WorkflowRun{}, fmt.Errorf("Dummy Error") }}
+		canceler.Provider = &MockGithubAPI{MockListWorkflows: func() ([]lib.WorkflowRun, error) { return []lib.WorkflowRun{}, fmt.Errorf("Dummy Error") }}
 		reqBody, err := json.Marshal(&lib.WorkflowRunAPIResponse{})
 		_, err = canceler.HandleRequest(events.APIGatewayProxyRequest{
 			Body:    string(reqBody),
@@ -81,7 +87,7 @@ func TestHandleRequest(t *testing.T) {
 	})
 
 	t.Run("Cancel run err should skip cancel and return 200", func(t *testing.T) {
-		canceler.GithubAPI = &MockGithubAPI{
+		canceler.Provider = &MockGithubAPI{
 			MockListWorkflows: func() ([]lib.WorkflowRun, error) {
 				return []lib.WorkflowRun{
 					lib.WorkflowRun{
@@ -116,7 +122,7 @@ func TestHandleRequest(t *testing.T) {
 	})
 
 	t.Run("Cancel run err should return internal server error", func(t *testing.T) {
-		canceler.GithubAPI = &MockGithubAPI{
+		canceler.Provider = &MockGithubAPI{
 			MockListWorkflows: func() ([]lib.WorkflowRun, error) { return []lib.WorkflowRun{}, nil },
 			MockCancelRun:     func(lib.WorkflowRun) error { return nil },
 		}
@@ -137,16 +143,161 @@ func TestHandleRequest(t *testing.T) {
 	})
 }
 
+func TestHandleRequestDedupe(t *testing.T) {
+	listCount := 0
+	canceler := AutomaticCancel{
+		Provider: &MockGithubAPI{
+			MockListWorkflows: func() ([]lib.WorkflowRun, error) {
+				listCount++
+				return []lib.WorkflowRun{}, nil
+			},
+		},
+		WebHookSecret: "secret",
+		Deduplicator:  lib.MakeDeduplicator(5*time.Minute, 10*time.Second),
+	}
+	send := func(deliveryID, body string) events.APIGatewayProxyResponse {
+		res, err := canceler.HandleRequest(events.APIGatewayProxyRequest{
+			Body: body,
+			Headers: map[string]string{
+				"X-Hub-Signature":   utils.SignPayload("secret", []byte(body)),
+				"X-GitHub-Delivery": deliveryID,
+			},
+		})
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		return res
+	}
+
+	send("guid-1", "dummy")
+	res := send("guid-1", "dummy")
+	if listCount != 1 {
+		t.Errorf("Redelivery should not list workflows, list count: %d", listCount)
+	}
+	if res.StatusCode != http.StatusOK || res.Body != "Duplicate delivery guid-1" {
+		t.Errorf("Bad response: %d %s", res.StatusCode, res.Body)
+	}
+
+	send("guid-2", `{"after":"abc","repository":{"full_name":"org/repo"}}`)
+	send("guid-3", `{"pull_request":{"head":{"sha":"abc"}},"repository":{"full_name":"org/repo"}}`)
+	if listCount != 2 {
+		t.Errorf("Events for the same commit should be coalesced, list count: %d", listCount)
+	}
+	sendEvent := func(deliveryID, event, body string) events.APIGatewayProxyResponse {
+		res, _ := canceler.HandleRequest(events.APIGatewayProxyRequest{
+			Body: body,
+			Headers: map[string]string{
+				"X-Hub-Signature":   utils.SignPayload("secret", []byte(body)),
+				"X-GitHub-Delivery": deliveryID,
+				"X-GitHub-Event":    event,
+			},
+		})
+		return res
+	}
+
+	t.Run("Workflow run events are not coalesced", func(t *testing.T) {
+		listCount = 0
+		sendEvent("guid-4", "push", `{"after":"def","repository":{"full_name":"org/repo"}}`)
+		sendEvent("guid-5", "workflow_run", `{"workflow_run":{"head_sha":"def"},"repository":{"full_name":"org/repo"}}`)
+		if listCount != 2 {
+			t.Errorf("Workflow run event should list the runs, list count: %d", listCount)
+		}
+	})
+
+	t.Run("Workflow job events are not coalesced", func(t *testing.T) {
+		listCount = 0
+		sendEvent("guid-7", "push", `{"after":"jkl","repository":{"full_name":"org/repo"}}`)
+		sendEvent("guid-8", "workflow_job", `{"workflow_job":{"head_sha":"jkl"},"after":"jkl","repository":{"full_name":"org/repo"}}`)
+		if listCount != 2 {
+			t.Errorf("Workflow job event should list the runs, list count: %d", listCount)
+		}
+	})
+
+	t.Run("Failed deliveries can be redelivered", func(t *testing.T) {
+		listCount = 0
+		failing := true
+		canceler.Provider = &MockGithubAPI{
+			MockListWorkflows: func() ([]lib.WorkflowRun, error) {
+				listCount++
+				if failing {
+					return nil, fmt.Errorf("Dummy Error")
+				}
+				return []lib.WorkflowRun{}, nil
+			},
+		}
+		body := `{"after":"ghi","repository":{"full_name":"org/repo"}}`
+
+		sendEvent("guid-6", "push", body)
+		failing = false
+		res := sendEvent("guid-6", "push", body)
+		if listCount != 2 || res.StatusCode != http.StatusOK {
+			t.Errorf("Redelivery of a failed delivery should be handled, list count: %d, status: %d", listCount, res.StatusCode)
+		}
+	})
+}
+
+func TestHandleRequestMetrics(t *testing.T) {
+	output := &bytes.Buffer{}
+	body := `{"after":"b","repository":{"full_name":"org/repo"}}`
+	canceler := AutomaticCancel{
+		Provider: &MockGithubAPI{
+			MockListWorkflows: func() ([]lib.WorkflowRun, error) {
+				return []lib.WorkflowRun{
+					lib.WorkflowRun{ID: 1, Name: "CI", HeadBranch: "master", HeadSHA: "a", Status: "queued", CreatedAt: time.Unix(1, 0)},
+					lib.WorkflowRun{ID: 2, Name: "CI", HeadBranch: "master", HeadSHA: "b", Status: "in_progress", CreatedAt: time.Unix(2, 0)},
+				}, nil
+			},
+			MockCancelRun: func(lib.WorkflowRun) error { return nil },
+		},
+		WebHookSecret: "secret",
+		Metrics:       lib.MakeEMFMetrics(output, "ActionsAutomaticCancel"),
+	}
+
+	_, err := canceler.HandleRequest(events.APIGatewayProxyRequest{
+		Body: body,
+		Headers: map[string]string{
+			"X-Hub-Signature": utils.SignPayload("secret", []byte(body)),
+			"X-GitHub-Event":  "push",
+		},
+	})
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+
+	records := make(map[string]map[string]interface{})
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		record := make(map[string]interface{})
+		json.Unmarshal([]byte(line), &record)
+		for _, name := range []string{lib.MetricRunsListed, lib.MetricRunsCancelled, lib.MetricEstimatedMinutesSaved, lib.MetricWebhookLatency} {
+			if _, ok := record[name]; ok {
+				records[name] = record
+			}
+		}
+	}
+	if records[lib.MetricRunsListed][lib.MetricRunsListed] != float64(2) || records[lib.MetricRunsListed]["Repository"] != "org/repo" {
+		t.Errorf("Bad listed runs: %v", records[lib.MetricRunsListed])
+	}
+	if records[lib.MetricRunsCancelled]["Repository"] != "org/repo" || records[lib.MetricRunsCancelled]["Workflow"] != "CI" {
+		t.Errorf("Bad cancelled runs: %v", records[lib.MetricRunsCancelled])
+	}
+	if records[lib.MetricEstimatedMinutesSaved][lib.MetricEstimatedMinutesSaved] != float64(10) {
+		t.Errorf("Bad minutes saved: %v", records[lib.MetricEstimatedMinutesSaved])
+	}
+	if records[lib.MetricWebhookLatency]["Event"] != "push" {
+		t.Errorf("Bad webhook latency: %v", records[lib.MetricWebhookLatency])
+	}
+}
+
 func TestAutomaticCancel(t *testing.T) {
 	canceler := AutomaticCancel{
-		GithubAPI:     &MockGithubAPI{},
+		Provider:      &MockGithubAPI{},
 		WebHookSecret: "secret",
 	}
 
 	t.Run("Should not cancel completed runs", func(t *testing.T) {
 		cancelCount := 0
 		var cancelCalls []lib.WorkflowRun
-		canceler.GithubAPI = &MockGithubAPI{MockCancelRun: func(run lib.WorkflowRun) error {
+		canceler.Provider = &MockGithubAPI{MockCancelRun: func(run lib.WorkflowRun) error {
 			cancelCount++
 			cancelCalls = append(cancelCalls, run)
 			return nil
@@ -177,7 +328,7 @@ func TestAutomaticCancel(t *testing.T) {
 	t.Run("Should not cancel different branches", func(t *testing.T) {
 		cancelCount := 0
 		var cancelCalls []lib.WorkflowRun
-		canceler.GithubAPI = &MockGithubAPI{MockCancelRun: func(run lib.WorkflowRun) error {
+		canceler.Provider = &MockGithubAPI{MockCancelRun: func(run lib.WorkflowRun) error {
 			cancelCount++
 			cancelCalls = append(cancelCalls, run)
 			return nil
@@ -208,7 +359,7 @@ func TestAutomaticCancel(t *testing.T) {
 	t.Run("Should cancel older runs", func(t *testing.T) {
 		cancelCount := 0
 		var cancelCalls []lib.WorkflowRun
-		canceler.GithubAPI = &MockGithubAPI{MockCancelRun: func(run lib.WorkflowRun) error {
+		canceler.Provider = &MockGithubAPI{MockCancelRun: func(run lib.WorkflowRun) error {
 			cancelCount++
 			cancelCalls = append(cancelCalls, run)
 			return nil
@@ -254,7 +405,7 @@ func TestAutomaticCancel(t *testing.T) {
 	t.Run("Should cancel older runs on multiple branch", func(t *testing.T) {
 		cancelCount := 0
 		var cancelCalls []lib.WorkflowRun
-		canceler.GithubAPI = &MockGithubAPI{MockCancelRun: func(run lib.WorkflowRun) error {
+		canceler.Provider = &MockGithubAPI{MockCancelRun: func(run lib.WorkflowRun) error {
 			cancelCount++
 			cancelCalls = append(cancelCalls, run)
 			return nil
@@ -307,10 +458,12 @@ func TestAutomaticCancel(t *testing.T) {
 
 func TestIntegrationHandleRequest(t *testing.T) {
 	canceler := AutomaticCancel{
-		GithubAPI: &lib.GithubAPI{
+		Provider: &lib.GithubAPI{
 			Organization: "org",
 			Repository:   "repo",
 			Token:        "dummytoken",
+			// gock only intercepts the default transport
+			Client: &http.Client{},
 		},
 		WebHookSecret: "secret",
 	}
@@ -480,3 +633,233 @@ func TestIntegrationHandleRequest(t *testing.T) {
 		}
 	})
 }
+
+func TestHandleRequestClosedPullRequest(t *testing.T) {
+	runs := []lib.WorkflowRun{
+		lib.WorkflowRun{ID: 1, HeadBranch: "feature", Status: "in_progress"},
+		lib.WorkflowRun{ID: 2, HeadBranch: "feature", Status: "completed"},
+		lib.WorkflowRun{ID: 3, HeadBranch: "feature", Status: "queued",
+			PullRequests: []lib.PullRequest{lib.PullRequest{Number: 7}}},
+		lib.WorkflowRun{ID: 4, HeadBranch: "other", Status: "queued"},
+	}
+	send := func(canceler AutomaticCancel, body string) events.APIGatewayProxyResponse {
+		res, err := canceler.HandleRequest(events.APIGatewayProxyRequest{
+			Body: body,
+			Headers: map[string]string{
+				"X-Hub-Signature": utils.SignPayload("secret", []byte(body)),
+				"X-GitHub-Event":  "pull_request",
+			},
+		})
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		return res
+	}
+	makeCanceler := func(cancelled *[]int64) AutomaticCancel {
+		return AutomaticCancel{
+			Provider: &MockGithubAPI{
+				MockListWorkflows: func() ([]lib.WorkflowRun, error) {
+					return runs, nil
+				},
+				MockCancelRun: func(run lib.WorkflowRun) error {
+					*cancelled = append(*cancelled, run.ID)
+					return nil
+				},
+			},
+			WebHookSecret: "secret",
+		}
+	}
+
+	t.Run("Cancels the runs of the pull request", func(t *testing.T) {
+		var cancelled []int64
+		res := send(makeCanceler(&cancelled),
+			`{"action":"closed","pull_request":{"number":7,"merged":true,"head":{"ref":"feature"}}}`)
+
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("Bad status code: %d", res.StatusCode)
+		}
+		if len(cancelled) != 2 || cancelled[0] != 3 || cancelled[1] != 1 {
+			t.Errorf("Bad cancelled runs: %v", cancelled)
+		}
+	})
+
+	t.Run("Keeps the runs of the repository's branch when a fork's pull request is closed", func(t *testing.T) {
+		var cancelled []int64
+		canceler := makeCanceler(&cancelled)
+		canceler.Provider.(*MockGithubAPI).MockListWorkflows = func() ([]lib.WorkflowRun, error) {
+			return []lib.WorkflowRun{
+				lib.WorkflowRun{ID: 1, HeadBranch: "feature", Status: "in_progress", HeadRepository: &lib.Repository{FullName: "org/repo"}},
+				lib.WorkflowRun{ID: 2, HeadBranch: "feature", Status: "in_progress", HeadRepository: &lib.Repository{FullName: "fork/repo"}},
+			}, nil
+		}
+		send(canceler, `{"action":"closed","repository":{"full_name":"org/repo"},`+
+			`"pull_request":{"number":7,"head":{"ref":"feature","repo":{"full_name":"fork/repo"}}}}`)
+
+		if len(cancelled) != 1 || cancelled[0] != 2 {
+			t.Errorf("Bad cancelled runs: %v", cancelled)
+		}
+	})
+
+	t.Run("Keeps the runs of branches with another open pull request", func(t *testing.T) {
+		var cancelled []int64
+		canceler := makeCanceler(&cancelled)
+		api := &mockOpenPullRequestAPI{MockGithubAPI: canceler.Provider.(*MockGithubAPI), open: []int64{9}}
+		canceler.Provider = api
+		res := send(canceler, `{"action":"closed","repository":{"full_name":"org/repo"},`+
+			`"pull_request":{"number":7,"head":{"ref":"feature","repo":{"full_name":"org/repo"}}}}`)
+
+		if res.Body != "Branch feature is still used by pull request #9" || len(cancelled) != 0 {
+			t.Errorf("Bad response: %s, cancelled runs: %v", res.Body, cancelled)
+		}
+		if api.head != "org/repo:feature" {
+			t.Errorf("Bad head: %s", api.head)
+		}
+
+		api.open = []int64{7}
+		send(canceler, `{"action":"closed","repository":{"full_name":"org/repo"},`+
+			`"pull_request":{"number":7,"head":{"ref":"feature","repo":{"full_name":"org/repo"}}}}`)
+		if len(cancelled) != 2 {
+			t.Errorf("Bad cancelled runs: %v", cancelled)
+		}
+	})
+
+	t.Run("Keeps the runs of protected branches", func(t *testing.T) {
+		var cancelled []int64
+		res := send(makeCanceler(&cancelled),
+			`{"action":"closed","pull_request":{"number":8,"head":{"ref":"master"}}}`)
+
+		if res.Body != "Branch master is protected" {
+			t.Errorf("Bad body: %s", res.Body)
+		}
+		if len(cancelled) != 0 {
+			t.Errorf("Bad cancelled runs: %v", cancelled)
+		}
+	})
+
+	t.Run("Dry run", func(t *testing.T) {
+		var cancelled []int64
+		canceler := makeCanceler(&cancelled)
+		canceler.DryRun = true
+		body := `{"action":"closed","pull_request":{"number":7,"head":{"ref":"feature"}}}`
+
+		req := events.APIGatewayProxyRequest{
+			Body: body,
+			Headers: map[string]string{
+				"X-Hub-Signature": utils.SignPayload("secret", []byte(body)),
+				"X-GitHub-Event":  "pull_request",
+			},
+		}
+		_, decisions, err := canceler.process(canceler.requestLogger("", req), req)
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		if len(cancelled) != 0 {
+			t.Errorf("Dry run should not cancel: %v", cancelled)
+		}
+		if len(decisions) != 2 || decisions[0].Reason != "pull request #7 was closed" {
+			t.Errorf("Bad decisions: %v", decisions)
+		}
+	})
+}
+
+type mockOpenPullRequestAPI struct {
+	*MockGithubAPI
+	open []int64
+	head string
+}
+
+func (api *mockOpenPullRequestAPI) ListOpenPullRequests(headRepository, branch string) ([]int64, error) {
+	api.head = headRepository + ":" + branch
+	return api.open, nil
+}
+
+func TestHandleRequestDeletedBranch(t *testing.T) {
+	runs := []lib.WorkflowRun{
+		lib.WorkflowRun{ID: 1, HeadBranch: "feature", Status: "in_progress"},
+		lib.WorkflowRun{ID: 2, HeadBranch: "feature", Status: "completed"},
+		lib.WorkflowRun{ID: 3, HeadBranch: "other", Status: "in_progress"},
+	}
+	send := func(event, body string) ([]int64, events.APIGatewayProxyResponse) {
+		var cancelled []int64
+		canceler := AutomaticCancel{
+			Provider: &MockGithubAPI{
+				MockListWorkflows: func() ([]lib.WorkflowRun, error) {
+					return runs, nil
+				},
+				MockCancelRun: func(run lib.WorkflowRun) error {
+					cancelled = append(cancelled, run.ID)
+					return nil
+				},
+			},
+			WebHookSecret: "secret",
+			Deduplicator:  lib.MakeDeduplicator(5*time.Minute, 10*time.Second),
+		}
+		res, err := canceler.HandleRequest(events.APIGatewayProxyRequest{
+			Body: body,
+			Headers: map[string]string{
+				"X-Hub-Signature": utils.SignPayload("secret", []byte(body)),
+				"X-GitHub-Event":  event,
+			},
+		})
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		return cancelled, res
+	}
+
+	t.Run("Delete event", func(t *testing.T) {
+		cancelled, res := send("delete", `{"ref":"feature","ref_type":"branch"}`)
+
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("Bad status code: %d", res.StatusCode)
+		}
+		if len(cancelled) != 1 || cancelled[0] != 1 {
+			t.Errorf("Bad cancelled runs: %v", cancelled)
+		}
+	})
+
+	t.Run("Push deleting the branch", func(t *testing.T) {
+		cancelled, _ := send("push", `{"ref":"refs/heads/feature","deleted":true,"after":"0000000000000000000000000000000000000000"}`)
+
+		if len(cancelled) != 1 || cancelled[0] != 1 {
+			t.Errorf("Bad cancelled runs: %v", cancelled)
+		}
+	})
+
+	t.Run("Deleted tag", func(t *testing.T) {
+		cancelled, _ := send("delete", `{"ref":"feature","ref_type":"tag"}`)
+
+		if len(cancelled) != 0 {
+			t.Errorf("Bad cancelled runs: %v", cancelled)
+		}
+	})
+
+	t.Run("Protected branch", func(t *testing.T) {
+		cancelled, res := send("delete", `{"ref":"main","ref_type":"branch"}`)
+
+		if res.Body != "Branch main is protected" || len(cancelled) != 0 {
+			t.Errorf("Bad response: %s, cancelled runs: %v", res.Body, cancelled)
+		}
+	})
+}
+
+func TestPollerFromEnv(t *testing.T) {
+	defer os.Unsetenv("POLL_INTERVAL")
+	defer os.Unsetenv("POLL_JITTER")
+
+	poller, err := pollerFromEnv()
+	if err != nil || poller.Interval != time.Minute || poller.Jitter != 10*time.Second || poller.MaxBackoff != 15*time.Minute {
+		t.Errorf("Bad poller: %+v %v", poller, err)
+	}
+
+	os.Setenv("POLL_INTERVAL", "0s")
+	if _, err := pollerFromEnv(); err == nil || err.Error() != "Bad POLL_INTERVAL: 0s is not a positive duration" {
+		t.Errorf("Bad error: %v", err)
+	}
+
+	os.Setenv("POLL_INTERVAL", "1m")
+	os.Setenv("POLL_JITTER", "ten seconds")
+	if _, err := pollerFromEnv(); err == nil || err.Error() != "Bad POLL_JITTER: ten seconds is not a duration like 30s" {
+		t.Errorf("Bad error: %v", err)
+	}
+}
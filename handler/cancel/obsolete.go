@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/urbpeti/actions-automatic-cancel/lib"
+)
+
+// obsoleteRuns describes runs whose results can't be used anymore
+type obsoleteRuns struct {
+	branch string
+	// repository the branch lives in, a fork's branch may have the name of one of the repository's own
+	repository string
+	fork       bool
+	// pullRequestNumber is the closed pull request, the branch may still have other ones
+	pullRequestNumber int64
+	reason            string
+}
+
+// matches reports whether the run is of the branch, runs without head repository only match by branch
+func (obsolete obsoleteRuns) matches(run lib.WorkflowRun) bool {
+	if run.HeadBranch != obsolete.branch {
+		return false
+	}
+
+	return obsolete.repository == "" || run.HeadRepository == nil || run.HeadRepository.FullName == obsolete.repository
+}
+
+// findObsoleteRuns reports whether the event made the runs of a branch obsolete
+func findObsoleteRuns(event string, payload lib.WebhookPayload) (obsoleteRuns, bool) {
+	if number, branch, ok := payload.ClosedPullRequest(event); ok {
+		reason := fmt.Sprintf("pull request #%d was closed", number)
+		if payload.PullRequest.Merged {
+			reason = fmt.Sprintf("pull request #%d was merged", number)
+		}
+		repository := payload.PullRequestHeadRepository()
+		return obsoleteRuns{
+			branch:            branch,
+			repository:        repository,
+			fork:              repository != payload.Repository.FullName,
+			pullRequestNumber: number,
+			reason:            reason,
+		}, true
+	}
+	if branch, ok := payload.DeletedBranch(event); ok {
+		return obsoleteRuns{
+			branch:     branch,
+			repository: payload.Repository.FullName,
+			reason:     fmt.Sprintf("branch %s was deleted", branch),
+		}, true
+	}
+
+	return obsoleteRuns{}, false
+}
+
+// otherOpenPullRequest returns another open pull request of the branch, or 0
+func (canceler *AutomaticCancel) otherOpenPullRequest(obsolete obsoleteRuns) (int64, error) {
+	lister, ok := canceler.Provider.(lib.OpenPullRequestLister)
+	if !ok || obsolete.pullRequestNumber == 0 {
+		return 0, nil
+	}
+
+	numbers, err := lister.ListOpenPullRequests(obsolete.repository, obsolete.branch)
+	if err != nil {
+		return 0, err
+	}
+	for _, number := range numbers {
+		if number != obsolete.pullRequestNumber {
+			return number, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// cancelObsolete cancels every active run of the branch unless the branch is protected
+// or the runs are still used by another pull request
+func (canceler *AutomaticCancel) cancelObsolete(policy *lib.Policy, req events.APIGatewayProxyRequest, obsolete obsoleteRuns) (events.APIGatewayProxyResponse, []lib.Decision, error) {
+	if !obsolete.fork && policy.IsProtected(obsolete.branch) {
+		body := fmt.Sprintf("Branch %s is protected", obsolete.branch)
+		return canceler.answer(policy.Logger, req, body), nil, nil
+	}
+	open, err := canceler.otherOpenPullRequest(obsolete)
+	if err != nil {
+		canceler.release(policy.Logger, req)
+		return events.APIGatewayProxyResponse{}, nil, err
+	}
+	if open != 0 {
+		body := fmt.Sprintf("Branch %s is still used by pull request #%d", obsolete.branch, open)
+		return canceler.answer(policy.Logger, req, body), nil, nil
+	}
+
+	runs, err := lib.ListBranchWorkflows(canceler.Provider, obsolete.branch)
+	if err != nil {
+		canceler.record(policy.Logger, req, nil, nil)
+		canceler.release(policy.Logger, req)
+		return events.APIGatewayProxyResponse{}, nil, err
+	}
+
+	var matching []lib.WorkflowRun
+	for _, run := range runs {
+		if obsolete.matches(run) {
+			matching = append(matching, run)
+		}
+	}
+
+	decisions := policy.DecideObsolete(canceler.Provider, matching, obsolete.reason)
+	err = canceler.apply(policy, decisions)
+	canceler.record(policy.Logger, req, runs, decisions)
+	if err != nil {
+		canceler.release(policy.Logger, req)
+	}
+
+	return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, decisions, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/urbpeti/actions-automatic-cancel/lib"
+)
+
+const redacted = "REDACTED"
+
+var sensitiveHeaderParts = []string{"authorization", "signature", "token", "secret", "cookie", "api-key"}
+
+// Recorder appends verified deliveries as JSON lines in the format read by ReadDeliveries
+type Recorder struct {
+	Writer  io.Writer
+	Secrets []string
+	Now     func() time.Time
+
+	mu sync.Mutex
+}
+
+// MakeRecorder creates a recorder writing to the sink, the secrets are scrubbed from every record
+func MakeRecorder(writer io.Writer, secrets ...string) *Recorder {
+	return &Recorder{Writer: writer, Secrets: secrets, Now: time.Now}
+}
+
+// OpenRecorder creates a recorder appending to the file
+func OpenRecorder(path string, secrets ...string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return MakeRecorder(file, secrets...), nil
+}
+
+func isSensitiveHeader(name string) bool {
+	lower := strings.ToLower(name)
+	for _, part := range sensitiveHeaderParts {
+		if strings.Contains(lower, part) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (recorder *Recorder) scrub(value string) string {
+	for _, secret := range recorder.Secrets {
+		if secret != "" {
+			value = strings.Replace(value, secret, redacted, -1)
+		}
+	}
+
+	return value
+}
+
+func (recorder *Recorder) redact(req events.APIGatewayProxyRequest) events.APIGatewayProxyRequest {
+	headers := make(map[string]string, len(req.Headers))
+	for name, value := range req.Headers {
+		if isSensitiveHeader(name) {
+			headers[name] = redacted
+		} else {
+			headers[name] = recorder.scrub(value)
+		}
+	}
+
+	return events.APIGatewayProxyRequest{Headers: headers, Body: recorder.scrub(req.Body)}
+}
+
+// Record writes a verified delivery with the runs it saw and the decisions made
+func (recorder *Recorder) Record(req events.APIGatewayProxyRequest, runs []lib.WorkflowRun, decisions []lib.Decision) error {
+	return recorder.write(Delivery{Request: req, Runs: runs, Decisions: decisions})
+}
+
+// RecordResponse writes a verified delivery answered without listing runs with the reason of the response
+func (recorder *Recorder) RecordResponse(req events.APIGatewayProxyRequest, response string) error {
+	return recorder.write(Delivery{Request: req, Response: response})
+}
+
+func (recorder *Recorder) write(delivery Delivery) error {
+	delivery.Timestamp = recorder.Now().UTC()
+	delivery.Request = recorder.redact(delivery.Request)
+	line, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	_, err = recorder.Writer.Write(append(line, '\n'))
+	return err
+}
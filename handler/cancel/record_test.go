@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/urbpeti/actions-automatic-cancel/lib"
+	"github.com/urbpeti/actions-automatic-cancel/utils"
+)
+
+func makeTestRecorder(output *bytes.Buffer) *Recorder {
+	recorder := MakeRecorder(output, "secret", "dummytoken")
+	recorder.Now = func() time.Time { return time.Date(2020, 02, 29, 0, 0, 5, 0, time.UTC) }
+	return recorder
+}
+
+func TestRecorder(t *testing.T) {
+	t.Run("Redacts auth headers and secrets", func(t *testing.T) {
+		output := &bytes.Buffer{}
+		recorder := makeTestRecorder(output)
+
+		err := recorder.Record(events.APIGatewayProxyRequest{
+			Headers: map[string]string{
+				"X-Hub-Signature": "sha1=2486c8590c396f876a46fb541e57fb3f9f276052",
+				"Authorization":   "token dummytoken",
+				"X-GitHub-Event":  "push",
+			},
+			Body: `{"note":"dummytoken"}`,
+		}, nil, nil)
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+
+		line := output.String()
+		if strings.Contains(line, "dummytoken") || strings.Contains(line, "2486c859") {
+			t.Errorf("Secret leaked: %s", line)
+		}
+		deliveries, err := ReadDeliveries(strings.NewReader(line))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		headers := deliveries[0].Request.Headers
+		if headers["X-Hub-Signature"] != redacted || headers["Authorization"] != redacted || headers["X-GitHub-Event"] != "push" {
+			t.Errorf("Bad headers: %v", headers)
+		}
+		if deliveries[0].Request.Body != `{"note":"REDACTED"}` {
+			t.Errorf("Bad body: %s", deliveries[0].Request.Body)
+		}
+		if !deliveries[0].Timestamp.Equal(time.Date(2020, 02, 29, 0, 0, 5, 0, time.UTC)) {
+			t.Errorf("Bad timestamp: %s", deliveries[0].Timestamp)
+		}
+	})
+
+	t.Run("Records verified deliveries only", func(t *testing.T) {
+		output := &bytes.Buffer{}
+		canceler := AutomaticCancel{
+			Provider: &MockGithubAPI{
+				MockListWorkflows: func() ([]lib.WorkflowRun, error) {
+					return []lib.WorkflowRun{
+						lib.WorkflowRun{ID: 1, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC), HeadBranch: "master", Status: "running"},
+						lib.WorkflowRun{ID: 2, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 1, time.UTC), HeadBranch: "master", Status: "running"},
+					}, nil
+				},
+				MockCancelRun: func(lib.WorkflowRun) error { return nil },
+			},
+			WebHookSecret: "secret",
+			Recorder:      makeTestRecorder(output),
+		}
+
+		canceler.HandleRequest(events.APIGatewayProxyRequest{
+			Body:    "dummy",
+			Headers: map[string]string{"X-Hub-Signature": "sha1=0000000000000000000000000000000000000000"},
+		})
+		if output.Len() != 0 {
+			t.Errorf("Unverified delivery was recorded: %s", output.String())
+		}
+
+		canceler.HandleRequest(events.APIGatewayProxyRequest{
+			Body:    "dummy",
+			Headers: map[string]string{"X-Hub-Signature": "sha1=2486c8590c396f876a46fb541e57fb3f9f276052"},
+		})
+		deliveries, err := ReadDeliveries(output)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if len(deliveries) != 1 || len(deliveries[0].Runs) != 2 || len(deliveries[0].Decisions) != 2 {
+			t.Fatalf("Bad recording: %+v", deliveries)
+		}
+		if !deliveries[0].Decisions[1].Cancel || deliveries[0].Decisions[1].Run.ID != 1 {
+			t.Errorf("Bad decisions: %+v", deliveries[0].Decisions)
+		}
+	})
+
+	t.Run("Records deliveries answered early with the reason", func(t *testing.T) {
+		output := &bytes.Buffer{}
+		canceler := AutomaticCancel{
+			Provider: &MockGithubAPI{
+				MockListWorkflows: func() ([]lib.WorkflowRun, error) { return nil, nil },
+			},
+			WebHookSecret: "secret",
+			Recorder:      makeTestRecorder(output),
+			Deduplicator:  lib.MakeDeduplicator(5*time.Minute, 10*time.Second),
+		}
+		send := func(deliveryID, event, body string) {
+			canceler.HandleRequest(events.APIGatewayProxyRequest{
+				Body: body,
+				Headers: map[string]string{
+					"X-Hub-Signature":   utils.SignPayload("secret", []byte(body)),
+					"X-GitHub-Delivery": deliveryID,
+					"X-GitHub-Event":    event,
+				},
+			})
+		}
+
+		send("guid-1", "push", "dummy")
+		send("guid-1", "push", "dummy")
+		send("guid-2", "pull_request", `{"action":"closed","pull_request":{"number":8,"head":{"ref":"master"}}}`)
+
+		deliveries, err := ReadDeliveries(output)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if len(deliveries) != 3 || deliveries[0].Response != "" {
+			t.Fatalf("Bad recording: %+v", deliveries)
+		}
+		if deliveries[1].Response != "Duplicate delivery guid-1" || deliveries[2].Response != "Branch master is protected" {
+			t.Errorf("Bad responses: %q %q", deliveries[1].Response, deliveries[2].Response)
+		}
+	})
+
+	t.Run("Recorded deliveries can be replayed", func(t *testing.T) {
+		output := &bytes.Buffer{}
+		recorder := makeTestRecorder(output)
+		recorder.Record(events.APIGatewayProxyRequest{
+			Headers: map[string]string{"X-Hub-Signature": "sha1=2486c8590c396f876a46fb541e57fb3f9f276052"},
+			Body:    "dummy",
+		}, []lib.WorkflowRun{
+			lib.WorkflowRun{ID: 1, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC), HeadBranch: "master", Status: "running"},
+			lib.WorkflowRun{ID: 2, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 1, time.UTC), HeadBranch: "master", Status: "running"},
+		}, []lib.Decision{
+			lib.Decision{Run: lib.WorkflowRun{ID: 2}, Key: "master"},
+			lib.Decision{Run: lib.WorkflowRun{ID: 1}, Key: "master", Cancel: true},
+		})
+
+		deliveries, err := ReadDeliveries(output)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		results := Replay(deliveries, lib.MakeDefaultPolicy(), "replaysecret", true)
+		if results[0].StatusCode != http.StatusOK {
+			t.Errorf("Expected status: %d, actual: %d", http.StatusOK, results[0].StatusCode)
+		}
+		if results[0].Changed() {
+			t.Errorf("Replay should match the recording: %v", results[0].Decisions)
+		}
+	})
+
+	t.Run("Write error does not fail the request", func(t *testing.T) {
+		canceler := AutomaticCancel{
+			Provider: &MockGithubAPI{
+				MockListWorkflows: func() ([]lib.WorkflowRun, error) { return []lib.WorkflowRun{}, nil },
+			},
+			WebHookSecret: "secret",
+			Recorder:      MakeRecorder(failingWriter{}),
+		}
+
+		res, err := canceler.HandleRequest(events.APIGatewayProxyRequest{
+			Body:    "dummy",
+			Headers: map[string]string{"X-Hub-Signature": "sha1=2486c8590c396f876a46fb541e57fb3f9f276052"},
+		})
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("Expected status: %d, actual: %d", http.StatusOK, res.StatusCode)
+		}
+	})
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, fmt.Errorf("Disk full")
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/urbpeti/actions-automatic-cancel/lib"
+	"github.com/urbpeti/actions-automatic-cancel/utils"
+)
+
+// Delivery is one line of a recorded deliveries JSONL file
+type Delivery struct {
+	Timestamp time.Time                     `json:"timestamp"`
+	Request   events.APIGatewayProxyRequest `json:"request"`
+	Runs      []lib.WorkflowRun             `json:"runs,omitempty"`
+	Decisions []lib.Decision                `json:"decisions,omitempty"`
+	// Response is the reason a delivery was answered with without listing runs, like a duplicate
+	Response string `json:"response,omitempty"`
+}
+
+// ReplayResult is the outcome of replaying a delivery
+type ReplayResult struct {
+	Index      int
+	Delivery   Delivery
+	StatusCode int
+	Decisions  []lib.Decision
+	Cancelled  []lib.WorkflowRun
+	Err        error
+}
+
+// Changed reports whether the replayed decisions differ from the recorded ones
+func (result ReplayResult) Changed() bool {
+	if len(result.Delivery.Decisions) == 0 {
+		return false
+	}
+	if len(result.Delivery.Decisions) != len(result.Decisions) {
+		return true
+	}
+
+	recorded := make(map[int64]bool)
+	for _, decision := range result.Delivery.Decisions {
+		recorded[decision.Run.ID] = decision.Cancel
+	}
+	for _, decision := range result.Decisions {
+		cancel, ok := recorded[decision.Run.ID]
+		if !ok || cancel != decision.Cancel {
+			return true
+		}
+	}
+
+	return false
+}
+
+// replayAPI serves the recorded runs and remembers the cancel calls
+type replayAPI struct {
+	runs      []lib.WorkflowRun
+	cancelled []lib.WorkflowRun
+}
+
+func (api *replayAPI) ListWorkflows() ([]lib.WorkflowRun, error) {
+	runs := make([]lib.WorkflowRun, len(api.runs))
+	copy(runs, api.runs)
+	return runs, nil
+}
+
+func (api *replayAPI) CancelRun(run lib.WorkflowRun) error {
+	api.cancelled = append(api.cancelled, run)
+	return nil
+}
+
+func (api *replayAPI) GroupKey(run lib.WorkflowRun) string {
+	return run.HeadBranch
+}
+
+// ReadDeliveries parses a JSONL file of recorded deliveries, a line may also be a bare API Gateway request
+func ReadDeliveries(reader io.Reader) ([]Delivery, error) {
+	var deliveries []Delivery
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var fields map[string]json.RawMessage
+		err := json.Unmarshal(scanner.Bytes(), &fields)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %s", line, err.Error())
+		}
+
+		delivery := Delivery{}
+		if _, ok := fields["request"]; ok {
+			err = json.Unmarshal(scanner.Bytes(), &delivery)
+		} else {
+			err = json.Unmarshal(scanner.Bytes(), &delivery.Request)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %s", line, err.Error())
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, scanner.Err()
+}
+
+// resign signs captured requests again, the recorder redacts their signature
+func resign(req events.APIGatewayProxyRequest, secret string) events.APIGatewayProxyRequest {
+	signers := map[string]func(string, []byte) string{
+		"X-Hub-Signature":     utils.SignPayload,
+		"X-Gitea-Signature":   utils.SignPayloadSHA256,
+		"X-Forgejo-Signature": utils.SignPayloadSHA256,
+		"X-Gitlab-Token": func(secret string, _ []byte) string {
+			return secret
+		},
+	}
+
+	headers := make(map[string]string, len(req.Headers))
+	for name, value := range req.Headers {
+		headers[name] = value
+	}
+	for name, value := range req.Headers {
+		for header, sign := range signers {
+			if strings.EqualFold(name, header) && value == redacted {
+				headers[name] = sign(secret, []byte(req.Body))
+			}
+		}
+	}
+	req.Headers = headers
+	return req
+}
+
+// webhookProviderOf returns the provider which signed the recorded request
+func webhookProviderOf(req events.APIGatewayProxyRequest) string {
+	if _, ok := utils.GetHeader(req.Headers, "X-Gitlab-Token"); ok {
+		return lib.ProviderGitLab
+	}
+	for _, header := range []string{"X-Gitea-Signature", "X-Forgejo-Signature"} {
+		if _, ok := utils.GetHeader(req.Headers, header); ok {
+			return lib.ProviderGitea
+		}
+	}
+
+	return lib.ProviderGitHub
+}
+
+// deliveryClock stops the time when the delivery was recorded, so runs have the age they had back then
+type deliveryClock time.Time
+
+func (clock deliveryClock) Now() time.Time {
+	return time.Time(clock)
+}
+
+func (clock deliveryClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Replay sends every delivery through HandleRequest against a fake github api,
+// deciding with the policy at the time the delivery was recorded
+func Replay(deliveries []Delivery, policy *lib.Policy, secret string, dryRun bool) []ReplayResult {
+	var results []ReplayResult
+	for i, delivery := range deliveries {
+		deliveryPolicy := *policy
+		if !delivery.Timestamp.IsZero() {
+			deliveryPolicy.Clock = deliveryClock(delivery.Timestamp)
+		}
+		api := &replayAPI{runs: delivery.Runs}
+		canceler := AutomaticCancel{
+			Provider:        api,
+			WebHookSecret:   secret,
+			WebhookProvider: webhookProviderOf(delivery.Request),
+			DryRun:          dryRun,
+			Policy:          &deliveryPolicy,
+		}
+
+		req := resign(delivery.Request, secret)
+		res, decisions, err := canceler.process(canceler.requestLogger("", req), req)
+		results = append(results, ReplayResult{
+			Index:      i + 1,
+			Delivery:   delivery,
+			StatusCode: res.StatusCode,
+			Decisions:  decisions,
+			Cancelled:  api.cancelled,
+			Err:        err,
+		})
+	}
+
+	return results
+}
+
+func printReplayResults(writer io.Writer, results []ReplayResult) {
+	for _, result := range results {
+		changed := ""
+		if result.Changed() {
+			changed = " CHANGED"
+		}
+		fmt.Fprintf(writer, "delivery %d: status %d%s\n", result.Index, result.StatusCode, changed)
+		if result.Err != nil {
+			fmt.Fprintf(writer, "  error: %s\n", result.Err.Error())
+		}
+		for _, decision := range result.Decisions {
+			action := "keep"
+			if decision.Cancel {
+				action = "cancel"
+			}
+			fmt.Fprintf(writer, "  run %d on %s: %s (%s)\n", decision.Run.ID, decision.Key, action, decision.Reason)
+		}
+	}
+}
+
+func runReplay(args []string, writer io.Writer) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", true, "only report the decisions, don't call CancelRun on the fake api")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("Usage: replay [--dry-run=false] deliveries.jsonl")
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	deliveries, err := ReadDeliveries(file)
+	if err != nil {
+		return err
+	}
+	policy, err := lib.MakePolicyFromEnv()
+	if err != nil {
+		return err
+	}
+
+	printReplayResults(writer, Replay(deliveries, policy, os.Getenv("WEBHOOK_SECRET"), *dryRun))
+	return nil
+}
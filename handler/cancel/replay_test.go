@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/urbpeti/actions-automatic-cancel/lib"
+	"github.com/urbpeti/actions-automatic-cancel/utils"
+)
+
+func readTestDeliveries(t *testing.T) []Delivery {
+	file, err := os.Open("testdata/deliveries.jsonl")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer file.Close()
+
+	deliveries, err := ReadDeliveries(file)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	return deliveries
+}
+
+func TestReadDeliveries(t *testing.T) {
+	t.Run("Reads records and bare requests", func(t *testing.T) {
+		deliveries := readTestDeliveries(t)
+
+		if len(deliveries) != 3 {
+			t.Fatalf("Expected 3 deliveries actual %d", len(deliveries))
+		}
+		if len(deliveries[0].Runs) != 2 || deliveries[0].Request.Body != "dummy" {
+			t.Errorf("Bad first delivery: %+v", deliveries[0])
+		}
+		if deliveries[1].Request.Headers["X-Hub-Signature"] != "sha1=0000000000000000000000000000000000000000" {
+			t.Errorf("Bare request was not parsed: %+v", deliveries[1])
+		}
+	})
+
+	t.Run("Bad line", func(t *testing.T) {
+		_, err := ReadDeliveries(strings.NewReader("{}\nnot json\n"))
+
+		if err == nil || !strings.HasPrefix(err.Error(), "Line 2:") {
+			t.Errorf("Bad error: %v", err)
+		}
+	})
+}
+
+func TestReplay(t *testing.T) {
+	deliveries := readTestDeliveries(t)
+
+	t.Run("Dry run reports decisions without cancelling", func(t *testing.T) {
+		results := Replay(deliveries, lib.MakeDefaultPolicy(), "secret", true)
+
+		if len(results) != 3 {
+			t.Fatalf("Expected 3 results actual %d", len(results))
+		}
+		if results[0].StatusCode != http.StatusOK || len(results[0].Decisions) != 2 {
+			t.Errorf("Bad first result: %+v", results[0])
+		}
+		if !results[0].Decisions[1].Cancel || results[0].Decisions[1].Run.ID != 1 {
+			t.Errorf("Run 1 should be cancelled")
+		}
+		if len(results[0].Cancelled) != 0 {
+			t.Errorf("Dry run should not cancel")
+		}
+		if results[0].Changed() {
+			t.Errorf("First delivery should match its recording")
+		}
+		if results[1].StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status: %d, actual: %d", http.StatusBadRequest, results[1].StatusCode)
+		}
+		if !results[2].Changed() {
+			t.Errorf("Third delivery should differ from its recording")
+		}
+	})
+
+	t.Run("Cancels on the fake api without dry run", func(t *testing.T) {
+		results := Replay(deliveries, lib.MakeDefaultPolicy(), "secret", false)
+
+		if len(results[0].Cancelled) != 1 || results[0].Cancelled[0].ID != 1 {
+			t.Errorf("Bad cancels: %v", results[0].Cancelled)
+		}
+	})
+
+	t.Run("Ages runs at the time of the delivery", func(t *testing.T) {
+		policy := lib.MakeDefaultPolicy()
+		policy.MinAge = time.Minute
+		results := Replay(deliveries, policy, "secret", true)
+
+		if results[0].Decisions[1].Cancel || results[0].Decisions[1].Reason != "created 5s ago, younger than 1m0s" {
+			t.Errorf("Bad decision: %+v", results[0].Decisions[1])
+		}
+	})
+
+	t.Run("Prints report", func(t *testing.T) {
+		output := &bytes.Buffer{}
+		printReplayResults(output, Replay(deliveries, lib.MakeDefaultPolicy(), "secret", true))
+
+		expected := "delivery 1: status 200\n" +
+			"  run 2 on master: keep (newest run)\n" +
+			"  run 1 on master: cancel (superseded by run 2)\n" +
+			"delivery 2: status 400\n" +
+			"delivery 3: status 200 CHANGED\n" +
+			"  run 4 on feature: keep (newest run)\n" +
+			"  run 3 on feature: cancel (superseded by run 4)\n"
+		if output.String() != expected {
+			t.Errorf("Bad report:\n%s", output.String())
+		}
+	})
+}
+
+func TestResign(t *testing.T) {
+	req := resign(events.APIGatewayProxyRequest{
+		Body: "dummy",
+		Headers: map[string]string{
+			"x-gitea-signature": redacted,
+			"X-Hub-Signature":   "sha1=recorded",
+		},
+	}, "secret")
+
+	if req.Headers["x-gitea-signature"] != utils.SignPayloadSHA256("secret", []byte("dummy")) {
+		t.Errorf("Bad gitea signature: %s", req.Headers["x-gitea-signature"])
+	}
+	if req.Headers["X-Hub-Signature"] != "sha1=recorded" {
+		t.Errorf("Signature should be kept: %s", req.Headers["X-Hub-Signature"])
+	}
+}
+
+func TestWebhookProviderOf(t *testing.T) {
+	providers := map[string]string{
+		"X-Hub-Signature":     lib.ProviderGitHub,
+		"x-forgejo-signature": lib.ProviderGitea,
+		"X-Gitlab-Token":      lib.ProviderGitLab,
+	}
+
+	for header, provider := range providers {
+		req := events.APIGatewayProxyRequest{Headers: map[string]string{header: redacted}}
+		if webhookProviderOf(req) != provider {
+			t.Errorf("Bad provider of %s: %s", header, webhookProviderOf(req))
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/urbpeti/actions-automatic-cancel/lib"
+)
+
+// maxPayloadSize is the largest webhook payload GitHub sends
+const maxPayloadSize = 25 << 20
+
+// ServeHTTP handles webhook deliveries sent straight to the server mode
+func (canceler *AutomaticCancel) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPayloadSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	headers := make(map[string]string, len(r.Header))
+	for name := range r.Header {
+		headers[name] = r.Header.Get(name)
+	}
+
+	res, err := canceler.handle(r.Header.Get("X-Request-Id"), events.APIGatewayProxyRequest{Headers: headers, Body: string(body)})
+	if err != nil && res.StatusCode == 0 {
+		res.StatusCode = http.StatusInternalServerError
+	}
+	for name, value := range res.Headers {
+		w.Header().Set(name, value)
+	}
+	w.WriteHeader(res.StatusCode)
+	w.Write([]byte(res.Body))
+}
+
+// makeServerMux routes the webhooks and the Prometheus metrics
+func makeServerMux(canceler *AutomaticCancel, metrics *lib.PrometheusMetrics) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	mux.Handle("/", canceler)
+	return mux
+}
+
+func runServer() error {
+	metrics := lib.MakePrometheusMetrics()
+	canceler, err := makeCanceler(metrics)
+	if err != nil {
+		return err
+	}
+	addr := os.Getenv("LISTEN_ADDR")
+	if addr == "" {
+		addr = ":8080"
+	}
+	server := &http.Server{
+		Addr:         addr,
+		Handler:      makeServerMux(canceler, metrics),
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: time.Minute,
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	lib.DefaultLogger().Info("Listening", lib.Fields{"addr": addr})
+	return serve(server, server.ListenAndServe, signals)
+}
+
+// serve runs listen until a signal arrives, then waits for the deliveries in flight before returning
+func serve(server *http.Server, listen func() error, signals <-chan os.Signal) error {
+	done := make(chan error, 1)
+	go func() {
+		<-signals
+		lib.DefaultLogger().Info("Shutting down server", nil)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		done <- server.Shutdown(ctx)
+	}()
+
+	err := listen()
+	if err != http.ErrServerClosed {
+		return err
+	}
+	// ListenAndServe returns as soon as the shutdown starts
+	return <-done
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/urbpeti/actions-automatic-cancel/lib"
+	"github.com/urbpeti/actions-automatic-cancel/utils"
+)
+
+func TestServer(t *testing.T) {
+	metrics := lib.MakePrometheusMetrics()
+	canceler := &AutomaticCancel{
+		Provider: &MockGithubAPI{
+			MockListWorkflows: func() ([]lib.WorkflowRun, error) {
+				return []lib.WorkflowRun{
+					lib.WorkflowRun{ID: 1, Name: "CI", HeadBranch: "master", HeadSHA: "a", Status: "in_progress", CreatedAt: time.Unix(1, 0)},
+					lib.WorkflowRun{ID: 2, Name: "CI", HeadBranch: "master", HeadSHA: "b", Status: "in_progress", CreatedAt: time.Unix(2, 0)},
+				}, nil
+			},
+			MockCancelRun: func(lib.WorkflowRun) error { return nil },
+		},
+		WebHookSecret: "secret",
+		Logger:        lib.MakeLogger(ioutil.Discard, lib.LevelError),
+		Metrics:       metrics,
+	}
+	server := httptest.NewServer(makeServerMux(canceler, metrics))
+	defer server.Close()
+	post := func(body, signature string) *http.Response {
+		req, _ := http.NewRequest("POST", server.URL+"/", strings.NewReader(body))
+		req.Header.Set("X-Hub-Signature", signature)
+		req.Header.Set("X-GitHub-Event", "push")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		res.Body.Close()
+		return res
+	}
+
+	body := `{"after":"b","repository":{"full_name":"org/repo"}}`
+	if res := post(body, utils.SignPayload("secret", []byte(body))); res.StatusCode != http.StatusOK {
+		t.Errorf("Bad status: %d", res.StatusCode)
+	}
+	if res := post(body, "sha1=bad"); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Bad status: %d", res.StatusCode)
+	}
+
+	res, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer res.Body.Close()
+	exposition, _ := ioutil.ReadAll(res.Body)
+	for _, line := range []string{
+		`actions_cancel_webhooks_total{event="push",outcome="handled"} 1`,
+		`actions_cancel_webhooks_total{event="push",outcome="rejected"} 1`,
+		`actions_cancel_cancels_total{repo="org/repo",workflow="CI",result="cancelled"} 1`,
+	} {
+		if !strings.Contains(string(exposition), line+"\n") {
+			t.Errorf("Missing %s: %s", line, exposition)
+		}
+	}
+
+	t.Run("Only accepts deliveries", func(t *testing.T) {
+		res, err := http.Get(server.URL + "/")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusMethodNotAllowed {
+			t.Errorf("Bad status: %d", res.StatusCode)
+		}
+	})
+}
+
+func TestServeWaitsForShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan bool)
+	release := make(chan bool)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		<-release
+	})}
+	signals := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(server, func() error { return server.Serve(listener) }, signals)
+	}()
+
+	go http.Get("http://" + listener.Addr().String())
+	<-started
+	signals <- os.Interrupt
+
+	select {
+	case err := <-served:
+		t.Fatalf("Returned before the delivery finished: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Bad error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Didn't return after the shutdown")
+	}
+}
+
+func TestWebhookOutcome(t *testing.T) {
+	tests := []struct {
+		name      string
+		res       events.APIGatewayProxyResponse
+		decisions []lib.Decision
+		err       error
+		expected  string
+	}{
+		{"Handled", events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, []lib.Decision{}, nil, "handled"},
+		{"Duplicate", events.APIGatewayProxyResponse{StatusCode: http.StatusOK, Body: "Duplicate delivery guid-1"}, nil, nil, "skipped"},
+		{"Bad signature", events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest}, nil, nil, "rejected"},
+		{"Listing failed", events.APIGatewayProxyResponse{}, nil, http.ErrHandlerTimeout, "error"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if outcome := webhookOutcome(test.res, test.decisions, test.err); outcome != test.expected {
+				t.Errorf("Bad outcome: %s", outcome)
+			}
+		})
+	}
+}
@@ -0,0 +1,714 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Decision is the outcome of the cancel policy for an active run
+type Decision struct {
+	Run    WorkflowRun `json:"run"`
+	Key    string      `json:"key"`
+	Cancel bool        `json:"cancel"`
+	Reason string      `json:"reason"`
+}
+
+// PullRequestLabeler is implemented by apis which can look up the labels of a pull request
+type PullRequestLabeler interface {
+	ListPullRequestLabels(number int64) ([]string, error)
+}
+
+// AncestryChecker is implemented by apis which can tell whether a commit is an ancestor of a branch head
+type AncestryChecker interface {
+	BranchHeadGetter
+	CompareCommits(base, head string) (string, error)
+}
+
+// StatusRule configures how superseded runs in a status are cancelled
+type StatusRule struct {
+	// Never keeps every run in the status
+	Never bool
+	// MaxAge only cancels runs which started less than MaxAge ago when not zero
+	MaxAge time.Duration
+}
+
+// ParseStatusRules parses a status=rule list, the rule is always, never or a maximum age like 10m
+func ParseStatusRules(value string) (map[string]StatusRule, error) {
+	rules := make(map[string]StatusRule)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.Split(item, "=")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Bad status rule format: %s", item)
+		}
+		status, rule := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		switch rule {
+		case "always":
+			rules[status] = StatusRule{}
+		case "never":
+			rules[status] = StatusRule{Never: true}
+		default:
+			maxAge, err := time.ParseDuration(rule)
+			if err != nil {
+				return nil, fmt.Errorf("Bad status rule for %s: %s", status, rule)
+			}
+			rules[status] = StatusRule{MaxAge: maxAge}
+		}
+	}
+
+	return rules, nil
+}
+
+// Policy decides which runs are cancelled
+type Policy struct {
+	// NoCancelMarker in the head commit message keeps the run
+	NoCancelMarker string
+	// KeepLabels on a pull request keep its runs
+	KeepLabels []string
+	// KeepWorkflows are workflow names or paths whose runs are never cancelled
+	KeepWorkflows []string
+	// IncludeWorkflows limits the policy to these workflow names or paths when not empty
+	IncludeWorkflows []string
+	// IncludeEvents limits the policy to runs triggered by these events when not empty
+	IncludeEvents []string
+	// ExcludeEvents are triggering events whose runs are left alone
+	ExcludeEvents []string
+	// StatusRules by run status, statuses without a rule are always cancelled
+	StatusRules map[string]StatusRule
+	// KeepActors never have their runs cancelled, a leading * matches a suffix like *[bot]
+	KeepActors []string
+	// AggressiveActors have their superseded runs cancelled regardless of MinAge and the MaxAge of StatusRules
+	AggressiveActors []string
+	// SameActorOnly only cancels runs of the actor who triggered the newest run
+	SameActorOnly bool
+	// MergeQueue is MergeQueueSkip to leave merge queue runs alone or MergeQueueRemoved
+	// to cancel the entries of pull requests which left the queue
+	MergeQueue string
+	// ProtectedBranches never have their runs cancelled because the branch or its pull request is gone
+	ProtectedBranches []string
+	// ProtectedJobs are job names whose superseded runs are only cancelled once these jobs completed
+	ProtectedJobs []string
+	// MinAge keeps runs which were created less than MinAge ago
+	MinAge time.Duration
+	// Clock is used to compute the age of runs
+	Clock Clock
+	// CheckAncestry only cancels runs whose commit is an ancestor of the branch head
+	CheckAncestry bool
+	// Logger writes the decisions and lookup failures, the default logger is used when nil
+	Logger *Logger
+	// Metrics receives the cancelled runs and failures when not nil
+	Metrics Metrics
+	// ExpectedRunDuration is how long a run usually takes, used to estimate the minutes saved by cancelling
+	ExpectedRunDuration time.Duration
+}
+
+// MakeDefaultPolicy creates the policy used when nothing is configured
+func MakeDefaultPolicy() *Policy {
+	return &Policy{
+		NoCancelMarker:      "[no-cancel]",
+		KeepLabels:          []string{"keep-all-runs"},
+		MergeQueue:          MergeQueueSkip,
+		ProtectedBranches:   []string{"main", "master"},
+		ExpectedRunDuration: 10 * time.Minute,
+		Clock:               RealClock,
+	}
+}
+
+func listFromEnv(name string, fallback []string) []string {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return fallback
+	}
+
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// DurationFromEnv parses a duration like 30s, unset variables keep the fallback
+func DurationFromEnv(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("Bad %s: %s is not a duration like 30s", name, value)
+	}
+	return duration, nil
+}
+
+// MakePolicyFromEnv creates the policy configured by environment variables
+func MakePolicyFromEnv() (*Policy, error) {
+	policy := MakeDefaultPolicy()
+	if marker, ok := os.LookupEnv("NO_CANCEL_MARKER"); ok {
+		policy.NoCancelMarker = marker
+	}
+	policy.KeepLabels = listFromEnv("KEEP_LABELS", policy.KeepLabels)
+	policy.KeepWorkflows = listFromEnv("KEEP_WORKFLOWS", policy.KeepWorkflows)
+	policy.IncludeWorkflows = listFromEnv("INCLUDE_WORKFLOWS", policy.IncludeWorkflows)
+	policy.IncludeEvents = listFromEnv("INCLUDE_EVENTS", policy.IncludeEvents)
+	policy.ExcludeEvents = listFromEnv("EXCLUDE_EVENTS", policy.ExcludeEvents)
+	policy.CheckAncestry = os.Getenv("CHECK_ANCESTRY") == "true"
+	policy.KeepActors = listFromEnv("KEEP_ACTORS", policy.KeepActors)
+	policy.AggressiveActors = listFromEnv("AGGRESSIVE_ACTORS", policy.AggressiveActors)
+	policy.SameActorOnly = os.Getenv("SAME_ACTOR_ONLY") == "true"
+	policy.ProtectedBranches = listFromEnv("PROTECTED_BRANCHES", policy.ProtectedBranches)
+	policy.ProtectedJobs = listFromEnv("PROTECTED_JOBS", policy.ProtectedJobs)
+	if os.Getenv("MERGE_QUEUE_MODE") == MergeQueueRemoved {
+		policy.MergeQueue = MergeQueueRemoved
+	}
+	minAge, err := DurationFromEnv("MIN_CANCEL_AGE", policy.MinAge)
+	if err != nil {
+		return nil, err
+	}
+	policy.MinAge = minAge
+	expected, err := DurationFromEnv("EXPECTED_RUN_DURATION", policy.ExpectedRunDuration)
+	if err != nil {
+		return nil, err
+	}
+	policy.ExpectedRunDuration = expected
+	rules, err := ParseStatusRules(os.Getenv("CANCEL_STATUS_RULES"))
+	if err != nil {
+		// Without the rules runs waiting for approval would be cancelled
+		return nil, fmt.Errorf("Bad CANCEL_STATUS_RULES: %s", err.Error())
+	}
+	policy.StatusRules = rules
+
+	return policy, nil
+}
+
+// sortRunsByCreatedAtDesc orders the newest run first, ties are broken by run number and then by id
+func sortRunsByCreatedAtDesc(runs []WorkflowRun) {
+	sort.Slice(runs, func(i, j int) bool {
+		if !runs[i].CreatedAt.Equal(runs[j].CreatedAt) {
+			return runs[i].CreatedAt.After(runs[j].CreatedAt)
+		}
+		if runs[i].RunNumber != runs[j].RunNumber {
+			return runs[i].RunNumber > runs[j].RunNumber
+		}
+		return runs[i].ID > runs[j].ID
+	})
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchesWorkflow reports whether the run's workflow is in the list by name, path or file name
+func matchesWorkflow(list []string, run WorkflowRun) bool {
+	for _, item := range list {
+		if item == run.Name || (run.Path != "" && (item == run.Path || item == path.Base(run.Path))) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// excluded returns why the policy doesn't apply to the run, or an empty string
+func (policy *Policy) excluded(run WorkflowRun) string {
+	if len(policy.IncludeWorkflows) > 0 && !matchesWorkflow(policy.IncludeWorkflows, run) {
+		return fmt.Sprintf("workflow %s is not included", run.Name)
+	}
+	if len(policy.IncludeEvents) > 0 && !contains(policy.IncludeEvents, run.Event) {
+		return fmt.Sprintf("event %s is not included", run.Event)
+	}
+	if contains(policy.ExcludeEvents, run.Event) {
+		return fmt.Sprintf("event %s is excluded", run.Event)
+	}
+
+	return ""
+}
+
+func (policy *Policy) now() time.Time {
+	if policy.Clock == nil {
+		return time.Now()
+	}
+
+	return policy.Clock.Now()
+}
+
+// statusRuleKeeps returns why the status rule keeps the run, or an empty string.
+// Aggressive runs are only kept by Never rules, their MaxAge is ignored.
+func (policy *Policy) statusRuleKeeps(run WorkflowRun, aggressive bool) string {
+	rule, ok := policy.StatusRules[run.Status]
+	if !ok {
+		return ""
+	}
+	if rule.Never {
+		return fmt.Sprintf("status %s is never cancelled", run.Status)
+	}
+	if aggressive {
+		return ""
+	}
+
+	started := run.RunStartedAt
+	if started.IsZero() {
+		started = run.CreatedAt
+	}
+	age := policy.now().Sub(started)
+	if rule.MaxAge > 0 && age >= rule.MaxAge {
+		return fmt.Sprintf("%s for %s, longer than %s", run.Status, age.Round(time.Second), rule.MaxAge)
+	}
+
+	return ""
+}
+
+// labelCache looks up every pull request at most once per decision round
+type labelCache struct {
+	labeler PullRequestLabeler
+	logger  *Logger
+	labels  map[int64][]string
+}
+
+func makeLabelCache(api Provider, logger *Logger) *labelCache {
+	labeler, ok := api.(PullRequestLabeler)
+	if !ok {
+		return nil
+	}
+
+	return &labelCache{labeler: labeler, logger: logger, labels: make(map[int64][]string)}
+}
+
+func (cache *labelCache) get(number int64) []string {
+	if labels, ok := cache.labels[number]; ok {
+		return labels
+	}
+
+	labels, err := cache.labeler.ListPullRequestLabels(number)
+	if err != nil {
+		cache.logger.Warn("Listing labels failed", Fields{"pull_request": number, "error": err})
+	}
+	cache.labels[number] = labels
+	return labels
+}
+
+// ancestryCache remembers the branch heads and comparisons of a decision round
+type ancestryCache struct {
+	checker  AncestryChecker
+	heads    map[string]string
+	statuses map[string]string
+}
+
+func (cache *ancestryCache) branchHead(branch string) (string, error) {
+	if head, ok := cache.heads[branch]; ok {
+		return head, nil
+	}
+
+	head, err := cache.checker.GetBranchHead(branch)
+	if err != nil {
+		return "", err
+	}
+	cache.heads[branch] = head
+	return head, nil
+}
+
+func (cache *ancestryCache) compare(base, head string) (string, error) {
+	key := base + "..." + head
+	if status, ok := cache.statuses[key]; ok {
+		return status, nil
+	}
+
+	status, err := cache.checker.CompareCommits(base, head)
+	if err != nil {
+		return "", err
+	}
+	cache.statuses[key] = status
+	return status, nil
+}
+
+// preferBranchHead makes the newest run of the branch head the newest run of its group,
+// after a force push a re-run of an old commit can be newer than the runs of the head
+func (cache *ancestryCache) preferBranchHead(active []WorkflowRun, groupKey func(WorkflowRun) string, newest map[string]WorkflowRun) {
+	heads := make(map[string]string)
+	found := make(map[string]bool)
+	for _, run := range active {
+		group := groupKey(run)
+		if found[group] {
+			continue
+		}
+		head, ok := heads[group]
+		if !ok {
+			// A failed lookup keeps the runs later, when the ancestry of a run is checked
+			head, _ = cache.branchHead(run.HeadBranch)
+			heads[group] = head
+		}
+		if head != "" && run.HeadSHA == head {
+			newest[group] = run
+			found[group] = true
+		}
+	}
+}
+
+// notSuperseded returns why the run's commit is not an ancestor of the branch head, or an empty string
+func (cache *ancestryCache) notSuperseded(run WorkflowRun) string {
+	if run.HeadSHA == "" {
+		return "commit unknown, ancestry can't be checked"
+	}
+
+	head, err := cache.branchHead(run.HeadBranch)
+	if err != nil {
+		return fmt.Sprintf("branch head unknown: %s", err.Error())
+	}
+	if head == run.HeadSHA {
+		return "commit is the branch head"
+	}
+
+	status, err := cache.compare(run.HeadSHA, head)
+	if err != nil {
+		return fmt.Sprintf("comparing with the branch head failed: %s", err.Error())
+	}
+	if status != "ahead" {
+		return fmt.Sprintf("commit is not an ancestor of the branch head (%s)", status)
+	}
+
+	return ""
+}
+
+// matchingActor returns the first login of the run matching the patterns, or an empty string
+func matchingActor(patterns []string, run WorkflowRun) string {
+	for _, login := range run.Logins() {
+		for _, pattern := range patterns {
+			if pattern == login || (strings.HasPrefix(pattern, "*") && strings.HasSuffix(login, pattern[1:])) {
+				return login
+			}
+		}
+	}
+
+	return ""
+}
+
+func triggeringLogin(run WorkflowRun) string {
+	logins := run.Logins()
+	if len(logins) == 0 {
+		return "unknown"
+	}
+
+	return logins[0]
+}
+
+// optOut returns why the run must not be cancelled, or an empty string
+func (policy *Policy) optOut(run WorkflowRun, labels *labelCache) string {
+	if matchesWorkflow(policy.KeepWorkflows, run) {
+		return fmt.Sprintf("workflow %s is never cancelled", run.Name)
+	}
+	if login := matchingActor(policy.KeepActors, run); login != "" {
+		return fmt.Sprintf("runs of %s are never cancelled", login)
+	}
+	if policy.NoCancelMarker != "" && run.HeadCommit != nil && strings.Contains(run.HeadCommit.Message, policy.NoCancelMarker) {
+		return fmt.Sprintf("opted out by %s in the commit message", policy.NoCancelMarker)
+	}
+	if len(policy.KeepLabels) > 0 && labels != nil {
+		for _, pullRequest := range run.PullRequests {
+			for _, label := range labels.get(pullRequest.Number) {
+				if contains(policy.KeepLabels, label) {
+					return fmt.Sprintf("opted out by label %s on #%d", label, pullRequest.Number)
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+// commitKey identifies the commit of a run, runs without head_sha count as separate commits
+func commitKey(run WorkflowRun) string {
+	if run.HeadSHA == "" {
+		return fmt.Sprintf("run:%d", run.ID)
+	}
+
+	return run.HeadSHA
+}
+
+// newestRunOfNewestCommit returns for every group the newest active run of the newest commit.
+// A commit is as old as its first listed run, completed ones included,
+// so re-runs don't make an old commit look new.
+// The runs must be sorted by CreatedAt descending.
+func newestRunOfNewestCommit(runs, active []WorkflowRun, groupKey func(WorkflowRun) string) map[string]WorkflowRun {
+	firstRun := make(map[string]time.Time)
+	for _, run := range runs {
+		firstRun[groupKey(run)+"\x00"+commitKey(run)] = run.CreatedAt
+	}
+
+	commitOrder := make(map[string][]string)
+	newestRun := make(map[string]WorkflowRun)
+	for _, run := range active {
+		key := groupKey(run) + "\x00" + commitKey(run)
+		if _, ok := newestRun[key]; !ok {
+			newestRun[key] = run
+			commitOrder[groupKey(run)] = append(commitOrder[groupKey(run)], commitKey(run))
+		}
+	}
+
+	newest := make(map[string]WorkflowRun)
+	for group, commits := range commitOrder {
+		newestCommit := commits[0]
+		for _, commit := range commits[1:] {
+			if firstRun[group+"\x00"+commit].After(firstRun[group+"\x00"+newestCommit]) {
+				newestCommit = commit
+			}
+		}
+		newest[group] = newestRun[group+"\x00"+newestCommit]
+	}
+
+	return newest
+}
+
+// keepSuperseded returns why a run of an older commit must still be kept, or an empty string
+func (policy *Policy) keepSuperseded(api Provider, run, newest WorkflowRun, labels *labelCache, ancestry *ancestryCache) string {
+	if reason := policy.optOut(run, labels); reason != "" {
+		return reason
+	}
+	if policy.SameActorOnly && triggeringLogin(run) != triggeringLogin(newest) {
+		return fmt.Sprintf("triggered by %s, the newest run by %s", triggeringLogin(run), triggeringLogin(newest))
+	}
+	aggressive := matchingActor(policy.AggressiveActors, run) != ""
+	if age := policy.now().Sub(run.CreatedAt); !aggressive && age < policy.MinAge {
+		return fmt.Sprintf("created %s ago, younger than %s", age.Round(time.Second), policy.MinAge)
+	}
+	if reason := policy.statusRuleKeeps(run, aggressive); reason != "" {
+		return reason
+	}
+	if ancestry != nil {
+		if reason := ancestry.notSuperseded(run); reason != "" {
+			return reason
+		}
+	}
+
+	return policy.protectedJobsRunning(api, run)
+}
+
+// Decide returns a decision for every active run, only the runs of the newest commit of a branch are kept.
+// The api is only used to look up pull request labels when it implements PullRequestLabeler,
+// and groups the runs by the GroupKey of the provider, the branch without a provider.
+func (policy *Policy) Decide(api Provider, runs []WorkflowRun) []Decision {
+	sortRunsByCreatedAtDesc(runs)
+
+	labels := makeLabelCache(api, policy.logger())
+	groupKey := groupKeyOf(api)
+	var ancestry *ancestryCache
+	if checker, ok := api.(AncestryChecker); ok && policy.CheckAncestry {
+		ancestry = &ancestryCache{checker: checker, heads: make(map[string]string), statuses: make(map[string]string)}
+	}
+
+	var decisions []Decision
+	var active []WorkflowRun
+	var mergeQueue []WorkflowRun
+	for _, run := range runs {
+		if run.Status == "completed" {
+			continue
+		}
+		if reason := policy.excluded(run); reason != "" {
+			decisions = append(decisions, Decision{Run: run, Key: groupKey(run), Reason: reason})
+			continue
+		}
+		if isMergeQueueRun(run) {
+			if policy.MergeQueue != MergeQueueRemoved {
+				decisions = append(decisions, Decision{Run: run, Key: mergeQueueKey(run), Reason: "merge queue runs are not deduplicated"})
+				continue
+			}
+			mergeQueue = append(mergeQueue, run)
+			continue
+		}
+		active = append(active, run)
+	}
+	decisions = append(decisions, policy.decideMergeQueue(api, mergeQueue, labels)...)
+	newestInGroup := newestRunOfNewestCommit(runs, active, groupKey)
+	if ancestry != nil {
+		ancestry.preferBranchHead(active, groupKey, newestInGroup)
+	}
+
+	for _, run := range active {
+		group := groupKey(run)
+		newest := newestInGroup[group]
+
+		if run.ID == newest.ID {
+			decisions = append(decisions, Decision{Run: run, Key: group, Reason: "newest run"})
+			continue
+		}
+		if commitKey(run) == commitKey(newest) {
+			reason := fmt.Sprintf("same commit as run %d", newest.ID)
+			if run.RunAttempt > 1 {
+				reason = fmt.Sprintf("attempt %d of the newest commit", run.RunAttempt)
+			}
+			decisions = append(decisions, Decision{Run: run, Key: group, Reason: reason})
+			continue
+		}
+
+		if reason := policy.keepSuperseded(api, run, newest, labels, ancestry); reason != "" {
+			decisions = append(decisions, Decision{Run: run, Key: group, Reason: reason})
+			continue
+		}
+
+		decisions = append(decisions, Decision{
+			Run:    run,
+			Key:    group,
+			Cancel: true,
+			Reason: fmt.Sprintf("superseded by run %d", newest.ID),
+		})
+	}
+
+	return decisions
+}
+
+// IsProtected reports whether the runs of the branch must outlive its pull request
+func (policy *Policy) IsProtected(branch string) bool {
+	return contains(policy.ProtectedBranches, branch)
+}
+
+// DecideObsolete cancels every active run, used when the results of the runs can't be used anymore.
+// Excluded and opted out runs are still kept.
+func (policy *Policy) DecideObsolete(api Provider, runs []WorkflowRun, reason string) []Decision {
+	sortRunsByCreatedAtDesc(runs)
+
+	labels := makeLabelCache(api, policy.logger())
+	groupKey := groupKeyOf(api)
+
+	var decisions []Decision
+	for _, run := range runs {
+		if run.Status == "completed" {
+			continue
+		}
+
+		keep := policy.excluded(run)
+		if keep == "" {
+			keep = policy.optOut(run, labels)
+		}
+		if keep != "" {
+			decisions = append(decisions, Decision{Run: run, Key: groupKey(run), Reason: keep})
+			continue
+		}
+
+		decisions = append(decisions, Decision{Run: run, Key: groupKey(run), Cancel: true, Reason: reason})
+	}
+
+	return decisions
+}
+
+func notStarted(run WorkflowRun) bool {
+	return run.Status == "queued" || run.Status == "requested" || run.Status == "pending"
+}
+
+// Apply cancels the runs the policy decided to cancel, runs which didn't start yet go first, and logs every decision.
+// Every run is tried, the error reports how many of them failed.
+func (policy *Policy) Apply(api Provider, decisions []Decision) error {
+	failed := 0
+	var lastErr error
+	logger := policy.logger()
+	metrics := metricsOrNop(policy.Metrics)
+	ordered := make([]Decision, len(decisions))
+	copy(ordered, decisions)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return notStarted(ordered[i].Run) && !notStarted(ordered[j].Run)
+	})
+
+	for _, decision := range ordered {
+		if !decision.Cancel {
+			LogDecision(logger, decision, "keep", nil)
+			continue
+		}
+
+		dimensions := Dimensions{"Workflow": decision.Run.Name}
+		err := api.CancelRun(decision.Run)
+		if err != nil {
+			LogDecision(logger, decision, "cancel failed", err)
+			metrics.Put(MetricCancelFailures, 1, UnitCount, dimensions)
+			failed++
+			lastErr = err
+			continue
+		}
+		LogDecision(logger, decision, "cancelled", nil)
+		metrics.Put(MetricRunsCancelled, 1, UnitCount, dimensions)
+		metrics.Put(MetricEstimatedMinutesSaved, policy.estimateSaved(decision.Run).Minutes(), UnitNone, dimensions)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("Cancelling %d runs failed: %s", failed, lastErr.Error())
+	}
+	return nil
+}
+
+// estimateSaved is the part of ExpectedRunDuration the run didn't spend running yet
+func (policy *Policy) estimateSaved(run WorkflowRun) time.Duration {
+	if notStarted(run) {
+		return policy.ExpectedRunDuration
+	}
+
+	started := run.RunStartedAt
+	if started.IsZero() {
+		started = run.CreatedAt
+	}
+	saved := policy.ExpectedRunDuration - policy.now().Sub(started)
+	if saved < 0 {
+		return 0
+	}
+	return saved
+}
+
+// LogDecision writes what happened to the run of the decision
+func LogDecision(logger *Logger, decision Decision, action string, err error) {
+	fields := Fields{
+		"run_id":   decision.Run.ID,
+		"branch":   decision.Run.HeadBranch,
+		"workflow": decision.Run.Name,
+		"group":    decision.Key,
+		"action":   action,
+		"reason":   decision.Reason,
+	}
+	if err != nil {
+		fields["error"] = err
+		logger.Error("Decision", fields)
+		return
+	}
+
+	logger.Info("Decision", fields)
+}
+
+// AutomaticCancel cancels every running workflow which has a newer run on the same branch
+func (policy *Policy) AutomaticCancel(api Provider, runs []WorkflowRun) error {
+	return policy.Apply(api, policy.Decide(api, runs))
+}
+
+// WithMetrics returns a copy of the policy sending its metrics to metrics
+func (policy *Policy) WithMetrics(metrics Metrics) *Policy {
+	copied := *policy
+	copied.Metrics = metrics
+	return &copied
+}
+
+// WithLogger returns a copy of the policy writing to the logger
+func (policy *Policy) WithLogger(logger *Logger) *Policy {
+	copied := *policy
+	copied.Logger = logger
+	return &copied
+}
+
+func (policy *Policy) logger() *Logger {
+	if policy.Logger == nil {
+		return DefaultLogger()
+	}
+
+	return policy.Logger
+}
@@ -0,0 +1,650 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockLabelAPI struct {
+	runs       []WorkflowRun
+	labels     map[int64][]string
+	labelCalls int
+}
+
+func (api *mockLabelAPI) ListWorkflows() ([]WorkflowRun, error) {
+	return api.runs, nil
+}
+
+func (api *mockLabelAPI) CancelRun(run WorkflowRun) error {
+	return nil
+}
+
+func (api *mockLabelAPI) GroupKey(run WorkflowRun) string {
+	return run.HeadBranch
+}
+
+func (api *mockLabelAPI) ListPullRequestLabels(number int64) ([]string, error) {
+	api.labelCalls++
+	return api.labels[number], nil
+}
+
+func TestDecide(t *testing.T) {
+	t.Run("Keeps newest run per branch", func(t *testing.T) {
+		decisions := MakeDefaultPolicy().Decide(nil, []WorkflowRun{
+			WorkflowRun{ID: 1, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC), HeadBranch: "master", Status: "running"},
+			WorkflowRun{ID: 2, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 1, time.UTC), HeadBranch: "master", Status: "running"},
+			WorkflowRun{ID: 3, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 2, time.UTC), HeadBranch: "master", Status: "completed"},
+		})
+
+		if len(decisions) != 2 {
+			t.Fatalf("Expected 2 decisions actual %d", len(decisions))
+		}
+		if decisions[0].Run.ID != 2 || decisions[0].Cancel {
+			t.Errorf("Run 2 should be kept")
+		}
+		if decisions[1].Run.ID != 1 || !decisions[1].Cancel || decisions[1].Reason != "superseded by run 2" {
+			t.Errorf("Run 1 should be cancelled, reason: %s", decisions[1].Reason)
+		}
+		if decisions[1].Key != "master" {
+			t.Errorf("Bad key: %s", decisions[1].Key)
+		}
+	})
+}
+
+func TestDecideOptOut(t *testing.T) {
+	newest := WorkflowRun{ID: 10, Name: "ci", CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 9, time.UTC), HeadBranch: "feature", Status: "in_progress"}
+
+	t.Run("Commit message marker", func(t *testing.T) {
+		decisions := MakeDefaultPolicy().Decide(nil, []WorkflowRun{
+			newest,
+			WorkflowRun{ID: 1, Name: "ci", CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC), HeadBranch: "feature", Status: "in_progress",
+				HeadCommit: &HeadCommit{ID: "abc", Message: "Backport hotfix [no-cancel]"}},
+		})
+
+		if decisions[1].Cancel || decisions[1].Reason != "opted out by [no-cancel] in the commit message" {
+			t.Errorf("Run should be kept, reason: %s", decisions[1].Reason)
+		}
+	})
+
+	t.Run("Pull request label", func(t *testing.T) {
+		api := &mockLabelAPI{labels: map[int64][]string{12: []string{"bug", "keep-all-runs"}}}
+		run := func(id int64) WorkflowRun {
+			return WorkflowRun{ID: id, Name: "ci", CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, int(id), time.UTC), HeadBranch: "feature", Status: "in_progress",
+				PullRequests: []PullRequest{PullRequest{Number: 12}}}
+		}
+
+		decisions := MakeDefaultPolicy().Decide(api, []WorkflowRun{newest, run(1), run(2)})
+
+		if decisions[1].Cancel || decisions[1].Reason != "opted out by label keep-all-runs on #12" {
+			t.Errorf("Run should be kept, reason: %s", decisions[1].Reason)
+		}
+		if decisions[2].Cancel {
+			t.Errorf("Run should be kept")
+		}
+		if api.labelCalls != 1 {
+			t.Errorf("Labels should be looked up once, calls: %d", api.labelCalls)
+		}
+	})
+
+	t.Run("Workflow allow list", func(t *testing.T) {
+		policy := MakeDefaultPolicy()
+		policy.KeepWorkflows = []string{"deploy"}
+
+		decisions := policy.Decide(nil, []WorkflowRun{
+			newest,
+			WorkflowRun{ID: 1, Name: "deploy", CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC), HeadBranch: "feature", Status: "in_progress"},
+			WorkflowRun{ID: 2, Name: "ci", CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 1, time.UTC), HeadBranch: "feature", Status: "in_progress"},
+		})
+
+		if decisions[1].Run.ID != 2 || !decisions[1].Cancel {
+			t.Errorf("Run 2 should be cancelled")
+		}
+		if decisions[2].Run.ID != 1 || decisions[2].Cancel || decisions[2].Reason != "workflow deploy is never cancelled" {
+			t.Errorf("Run 1 should be kept, reason: %s", decisions[2].Reason)
+		}
+	})
+}
+
+func TestMakePolicyFromEnv(t *testing.T) {
+	os.Setenv("KEEP_LABELS", "keep, release")
+	os.Setenv("KEEP_WORKFLOWS", "deploy,nightly")
+	defer os.Unsetenv("KEEP_LABELS")
+	defer os.Unsetenv("KEEP_WORKFLOWS")
+
+	policy, err := MakePolicyFromEnv()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if !reflect.DeepEqual(policy.KeepLabels, []string{"keep", "release"}) {
+		t.Errorf("Bad keep labels: %v", policy.KeepLabels)
+	}
+	if !reflect.DeepEqual(policy.KeepWorkflows, []string{"deploy", "nightly"}) {
+		t.Errorf("Bad keep workflows: %v", policy.KeepWorkflows)
+	}
+	if policy.NoCancelMarker != "[no-cancel]" {
+		t.Errorf("Bad marker: %s", policy.NoCancelMarker)
+	}
+}
+
+func TestMakePolicyFromEnvBadStatusRules(t *testing.T) {
+	os.Setenv("CANCEL_STATUS_RULES", "waiting=nevr")
+	defer os.Unsetenv("CANCEL_STATUS_RULES")
+
+	_, err := MakePolicyFromEnv()
+
+	if err == nil || !strings.HasPrefix(err.Error(), "Bad CANCEL_STATUS_RULES") {
+		t.Errorf("Bad error: %v", err)
+	}
+}
+
+func TestMakePolicyFromEnvBadMinAge(t *testing.T) {
+	os.Setenv("MIN_CANCEL_AGE", "30")
+	defer os.Unsetenv("MIN_CANCEL_AGE")
+
+	_, err := MakePolicyFromEnv()
+
+	if err == nil || err.Error() != "Bad MIN_CANCEL_AGE: 30 is not a duration like 30s" {
+		t.Errorf("Bad error: %v", err)
+	}
+}
+
+func TestDecideHeadSHA(t *testing.T) {
+	t.Run("Keeps re-runs and other workflows of the same commit", func(t *testing.T) {
+		decisions := MakeDefaultPolicy().Decide(nil, []WorkflowRun{
+			WorkflowRun{ID: 1, Name: "ci", CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC), HeadBranch: "master", HeadSHA: "abc", RunAttempt: 1, Status: "in_progress"},
+			WorkflowRun{ID: 2, Name: "lint", CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 1, time.UTC), HeadBranch: "master", HeadSHA: "abc", RunAttempt: 1, Status: "in_progress"},
+			WorkflowRun{ID: 3, Name: "ci", CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 2, time.UTC), HeadBranch: "master", HeadSHA: "abc", RunAttempt: 1, Status: "queued"},
+			WorkflowRun{ID: 4, Name: "ci", CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 3, time.UTC), HeadBranch: "master", HeadSHA: "abc", RunAttempt: 2, Status: "queued"},
+		})
+
+		for _, decision := range decisions {
+			if decision.Cancel {
+				t.Errorf("Run %d should be kept", decision.Run.ID)
+			}
+		}
+		if decisions[1].Reason != "same commit as run 4" {
+			t.Errorf("Bad reason: %s", decisions[1].Reason)
+		}
+	})
+
+	t.Run("Cancels runs of superseded commits", func(t *testing.T) {
+		decisions := MakeDefaultPolicy().Decide(nil, []WorkflowRun{
+			WorkflowRun{ID: 1, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC), HeadBranch: "master", HeadSHA: "old", Status: "in_progress"},
+			WorkflowRun{ID: 2, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 1, time.UTC), HeadBranch: "master", HeadSHA: "new", Status: "in_progress"},
+			WorkflowRun{ID: 3, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 2, time.UTC), HeadBranch: "master", HeadSHA: "new", RunAttempt: 2, Status: "in_progress"},
+		})
+
+		if decisions[0].Run.ID != 3 || decisions[0].Cancel {
+			t.Errorf("Run 3 should be kept")
+		}
+		if decisions[1].Run.ID != 2 || decisions[1].Cancel || decisions[1].Reason != "same commit as run 3" {
+			t.Errorf("Run 2 should be kept, reason: %s", decisions[1].Reason)
+		}
+		if decisions[2].Run.ID != 1 || !decisions[2].Cancel || decisions[2].Reason != "superseded by run 3" {
+			t.Errorf("Run 1 should be cancelled, reason: %s", decisions[2].Reason)
+		}
+	})
+
+	t.Run("Re-run of an old commit does not supersede the new commit", func(t *testing.T) {
+		decisions := MakeDefaultPolicy().Decide(nil, []WorkflowRun{
+			WorkflowRun{ID: 1, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC), HeadBranch: "master", HeadSHA: "old", Status: "completed"},
+			WorkflowRun{ID: 2, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 1, time.UTC), HeadBranch: "master", HeadSHA: "new", Status: "in_progress"},
+			WorkflowRun{ID: 3, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 2, time.UTC), HeadBranch: "master", HeadSHA: "old", RunAttempt: 2, Status: "in_progress"},
+			WorkflowRun{ID: 4, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC), HeadBranch: "master", HeadSHA: "old", Status: "in_progress"},
+		})
+
+		for _, decision := range decisions {
+			if decision.Run.ID == 2 && decision.Cancel {
+				t.Errorf("Run of the new commit should be kept")
+			}
+			if decision.Run.ID != 2 && !decision.Cancel {
+				t.Errorf("Run %d of the old commit should be cancelled", decision.Run.ID)
+			}
+		}
+	})
+
+	t.Run("Completed runs date their commit", func(t *testing.T) {
+		decisions := MakeDefaultPolicy().Decide(nil, []WorkflowRun{
+			WorkflowRun{ID: 1, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC), HeadBranch: "master", HeadSHA: "a", Status: "completed"},
+			WorkflowRun{ID: 2, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 1, time.UTC), HeadBranch: "master", HeadSHA: "b", Status: "in_progress"},
+			WorkflowRun{ID: 3, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 2, time.UTC), HeadBranch: "master", HeadSHA: "a", RunAttempt: 2, Status: "in_progress"},
+		})
+
+		if len(decisions) != 2 {
+			t.Fatalf("Bad decisions: %v", decisions)
+		}
+		if decisions[0].Run.ID != 3 || !decisions[0].Cancel || decisions[0].Reason != "superseded by run 2" {
+			t.Errorf("Re-run of a should be cancelled, reason: %s", decisions[0].Reason)
+		}
+		if decisions[1].Run.ID != 2 || decisions[1].Cancel {
+			t.Errorf("Run of b should be kept, reason: %s", decisions[1].Reason)
+		}
+	})
+}
+
+type mockAncestryAPI struct {
+	mockLabelAPI
+	heads        map[string]string
+	statuses     map[string]string
+	compareCalls int
+}
+
+func (api *mockAncestryAPI) GetBranchHead(branch string) (string, error) {
+	head, ok := api.heads[branch]
+	if !ok {
+		return "", fmt.Errorf("Branch not found")
+	}
+	return head, nil
+}
+
+func (api *mockAncestryAPI) CompareCommits(base, head string) (string, error) {
+	api.compareCalls++
+	return api.statuses[base+"..."+head], nil
+}
+
+func TestDecideAncestry(t *testing.T) {
+	policy := MakeDefaultPolicy()
+	policy.CheckAncestry = true
+
+	t.Run("Cancels ancestors of the branch head only", func(t *testing.T) {
+		api := &mockAncestryAPI{
+			heads:    map[string]string{"feature": "head"},
+			statuses: map[string]string{"ancestor...head": "ahead", "rebased...head": "diverged"},
+		}
+
+		decisions := policy.Decide(api, []WorkflowRun{
+			WorkflowRun{ID: 1, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC), HeadBranch: "feature", HeadSHA: "ancestor", Status: "in_progress"},
+			WorkflowRun{ID: 2, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 1, time.UTC), HeadBranch: "feature", HeadSHA: "ancestor", Status: "in_progress"},
+			WorkflowRun{ID: 3, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 2, time.UTC), HeadBranch: "feature", HeadSHA: "rebased", Status: "in_progress"},
+			WorkflowRun{ID: 4, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 3, time.UTC), HeadBranch: "feature", HeadSHA: "head", Status: "in_progress"},
+		})
+
+		expected := map[int64]string{
+			4: "newest run",
+			3: "commit is not an ancestor of the branch head (diverged)",
+			2: "superseded by run 4",
+			1: "superseded by run 4",
+		}
+		for _, decision := range decisions {
+			if decision.Reason != expected[decision.Run.ID] {
+				t.Errorf("Run %d bad reason: %s", decision.Run.ID, decision.Reason)
+			}
+		}
+		if api.compareCalls != 2 {
+			t.Errorf("Comparisons should be cached, calls: %d", api.compareCalls)
+		}
+	})
+
+	t.Run("Keeps the branch head when an old commit looks newer", func(t *testing.T) {
+		api := &mockAncestryAPI{
+			heads:    map[string]string{"feature": "head"},
+			statuses: map[string]string{"old...head": "ahead"},
+		}
+
+		decisions := policy.Decide(api, []WorkflowRun{
+			WorkflowRun{ID: 1, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC), HeadBranch: "feature", HeadSHA: "head", Status: "in_progress"},
+			WorkflowRun{ID: 2, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 1, time.UTC), HeadBranch: "feature", HeadSHA: "old", Status: "in_progress"},
+		})
+
+		if decisions[1].Run.ID != 1 || decisions[1].Cancel || decisions[1].Reason != "newest run" {
+			t.Errorf("Branch head should be kept, reason: %s", decisions[1].Reason)
+		}
+		if decisions[0].Run.ID != 2 || !decisions[0].Cancel || decisions[0].Reason != "superseded by run 1" {
+			t.Errorf("Old commit should be cancelled, reason: %s", decisions[0].Reason)
+		}
+	})
+
+	t.Run("Keeps runs when the branch head is unknown", func(t *testing.T) {
+		api := &mockAncestryAPI{heads: map[string]string{}}
+
+		decisions := policy.Decide(api, []WorkflowRun{
+			WorkflowRun{ID: 1, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC), HeadBranch: "feature", HeadSHA: "old", Status: "in_progress"},
+			WorkflowRun{ID: 2, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 1, time.UTC), HeadBranch: "feature", HeadSHA: "new", Status: "in_progress"},
+		})
+
+		if decisions[1].Cancel || decisions[1].Reason != "branch head unknown: Branch not found" {
+			t.Errorf("Run should be kept, reason: %s", decisions[1].Reason)
+		}
+	})
+}
+
+func TestDecideWorkflowFilters(t *testing.T) {
+	runs := func() []WorkflowRun {
+		return []WorkflowRun{
+			WorkflowRun{ID: 1, Name: "CI", Path: ".github/workflows/ci.yml", Event: "push", CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC), HeadBranch: "master", HeadSHA: "old", Status: "in_progress"},
+			WorkflowRun{ID: 2, Name: "Deploy", Path: ".github/workflows/deploy.yml", Event: "push", CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 1, time.UTC), HeadBranch: "master", HeadSHA: "old", Status: "in_progress"},
+			WorkflowRun{ID: 3, Name: "Nightly", Path: ".github/workflows/nightly.yml", Event: "schedule", CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 2, time.UTC), HeadBranch: "master", HeadSHA: "old", Status: "in_progress"},
+			WorkflowRun{ID: 4, Name: "CI", Path: ".github/workflows/ci.yml", Event: "push", CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 3, time.UTC), HeadBranch: "master", HeadSHA: "new", Status: "in_progress"},
+		}
+	}
+	reasons := func(decisions []Decision) map[int64]string {
+		reasons := make(map[int64]string)
+		for _, decision := range decisions {
+			reasons[decision.Run.ID] = decision.Reason
+			if decision.Cancel {
+				reasons[decision.Run.ID] = "cancel"
+			}
+		}
+		return reasons
+	}
+
+	t.Run("Keep workflows by path and file name", func(t *testing.T) {
+		policy := MakeDefaultPolicy()
+		policy.KeepWorkflows = []string{".github/workflows/deploy.yml", "nightly.yml"}
+
+		actual := reasons(policy.Decide(nil, runs()))
+
+		if actual[1] != "cancel" || actual[2] != "workflow Deploy is never cancelled" || actual[3] != "workflow Nightly is never cancelled" {
+			t.Errorf("Bad decisions: %v", actual)
+		}
+	})
+
+	t.Run("Include workflows", func(t *testing.T) {
+		policy := MakeDefaultPolicy()
+		policy.IncludeWorkflows = []string{"ci.yml"}
+
+		actual := reasons(policy.Decide(nil, runs()))
+
+		if actual[1] != "cancel" || actual[2] != "workflow Deploy is not included" || actual[3] != "workflow Nightly is not included" {
+			t.Errorf("Bad decisions: %v", actual)
+		}
+	})
+
+	t.Run("Exclude events", func(t *testing.T) {
+		policy := MakeDefaultPolicy()
+		policy.ExcludeEvents = []string{"schedule", "workflow_dispatch", "release"}
+
+		actual := reasons(policy.Decide(nil, runs()))
+
+		if actual[1] != "cancel" || actual[2] != "cancel" || actual[3] != "event schedule is excluded" {
+			t.Errorf("Bad decisions: %v", actual)
+		}
+	})
+
+	t.Run("Include events", func(t *testing.T) {
+		policy := MakeDefaultPolicy()
+		policy.IncludeEvents = []string{"schedule"}
+
+		actual := reasons(policy.Decide(nil, runs()))
+
+		if actual[1] != "event push is not included" || actual[3] != "newest run" {
+			t.Errorf("Bad decisions: %v", actual)
+		}
+	})
+}
+
+func TestParseStatusRules(t *testing.T) {
+	t.Run("Parses rules", func(t *testing.T) {
+		rules, err := ParseStatusRules("queued=always, in_progress=10m,waiting=never")
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+
+		expected := map[string]StatusRule{
+			"queued":      StatusRule{},
+			"in_progress": StatusRule{MaxAge: 10 * time.Minute},
+			"waiting":     StatusRule{Never: true},
+		}
+		if !reflect.DeepEqual(rules, expected) {
+			t.Errorf("Bad rules: %v", rules)
+		}
+	})
+
+	t.Run("Bad rule", func(t *testing.T) {
+		_, err := ParseStatusRules("queued=sometimes")
+		if err == nil || err.Error() != "Bad status rule for queued: sometimes" {
+			t.Errorf("Bad error: %v", err)
+		}
+	})
+}
+
+func TestDecideStatusRules(t *testing.T) {
+	start := time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC)
+	policy := MakeDefaultPolicy()
+	policy.Clock = &fakeClock{now: start.Add(time.Hour)}
+	policy.StatusRules = map[string]StatusRule{
+		"queued":      StatusRule{},
+		"in_progress": StatusRule{MaxAge: 10 * time.Minute},
+		"waiting":     StatusRule{Never: true},
+	}
+
+	decisions := policy.Decide(nil, []WorkflowRun{
+		WorkflowRun{ID: 1, CreatedAt: start, HeadBranch: "master", Status: "queued"},
+		WorkflowRun{ID: 2, CreatedAt: start, RunStartedAt: start.Add(55 * time.Minute), HeadBranch: "master", Status: "in_progress"},
+		WorkflowRun{ID: 3, CreatedAt: start, RunStartedAt: start.Add(30 * time.Minute), HeadBranch: "master", Status: "in_progress"},
+		WorkflowRun{ID: 4, CreatedAt: start, HeadBranch: "master", Status: "waiting"},
+		WorkflowRun{ID: 5, CreatedAt: start.Add(time.Minute), HeadBranch: "master", Status: "in_progress"},
+	})
+
+	expected := map[int64]string{
+		1: "superseded by run 5",
+		2: "superseded by run 5",
+		3: "in_progress for 30m0s, longer than 10m0s",
+		4: "status waiting is never cancelled",
+		5: "newest run",
+	}
+	for _, decision := range decisions {
+		if decision.Reason != expected[decision.Run.ID] {
+			t.Errorf("Run %d bad reason: %s", decision.Run.ID, decision.Reason)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	api := &mockPollAPI{clock: &fakeClock{}}
+	logger, _ := makeTestLogger(LevelError)
+
+	MakeDefaultPolicy().WithLogger(logger).Apply(api, []Decision{
+		Decision{Run: WorkflowRun{ID: 1, Status: "in_progress"}, Cancel: true},
+		Decision{Run: WorkflowRun{ID: 2, Status: "queued"}, Cancel: true},
+		Decision{Run: WorkflowRun{ID: 3, Status: "in_progress"}},
+		Decision{Run: WorkflowRun{ID: 4, Status: "in_progress"}, Cancel: true},
+	})
+
+	var ids []int64
+	for _, run := range api.cancels {
+		ids = append(ids, run.ID)
+	}
+	if !reflect.DeepEqual(ids, []int64{2, 1, 4}) {
+		t.Errorf("Queued runs should be cancelled first: %v", ids)
+	}
+}
+
+func TestDecideDebounce(t *testing.T) {
+	start := time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC)
+	policy := MakeDefaultPolicy()
+	policy.Clock = &fakeClock{now: start.Add(time.Minute)}
+	policy.MinAge = 30 * time.Second
+
+	decisions := policy.Decide(nil, []WorkflowRun{
+		WorkflowRun{ID: 1, CreatedAt: start, HeadBranch: "master", Status: "in_progress"},
+		WorkflowRun{ID: 2, CreatedAt: start.Add(40 * time.Second), HeadBranch: "master", Status: "in_progress"},
+		WorkflowRun{ID: 3, CreatedAt: start.Add(50 * time.Second), HeadBranch: "master", Status: "in_progress"},
+	})
+
+	expected := map[int64]string{
+		1: "superseded by run 3",
+		2: "created 20s ago, younger than 30s",
+		3: "newest run",
+	}
+	for _, decision := range decisions {
+		if decision.Reason != expected[decision.Run.ID] {
+			t.Errorf("Run %d bad reason: %s", decision.Run.ID, decision.Reason)
+		}
+	}
+}
+
+func TestSortRunsByCreatedAtDescTies(t *testing.T) {
+	createdAt := time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC)
+
+	t.Run("Equal timestamps are ordered by run number then id", func(t *testing.T) {
+		runs := []WorkflowRun{
+			WorkflowRun{ID: 5, RunNumber: 10, CreatedAt: createdAt},
+			WorkflowRun{ID: 2, RunNumber: 12, CreatedAt: createdAt},
+			WorkflowRun{ID: 9, RunNumber: 11, CreatedAt: createdAt},
+			WorkflowRun{ID: 7, RunNumber: 12, CreatedAt: createdAt},
+			WorkflowRun{ID: 1, RunNumber: 1, CreatedAt: createdAt.Add(time.Second)},
+		}
+
+		sortRunsByCreatedAtDesc(runs)
+
+		var ids []int64
+		for _, run := range runs {
+			ids = append(ids, run.ID)
+		}
+		if !reflect.DeepEqual(ids, []int64{1, 7, 2, 9, 5}) {
+			t.Errorf("Bad order: %v", ids)
+		}
+	})
+
+	t.Run("Newest of equal timestamps is kept", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			decisions := MakeDefaultPolicy().Decide(nil, []WorkflowRun{
+				WorkflowRun{ID: 101, RunNumber: 1, CreatedAt: createdAt, HeadBranch: "master", Status: "in_progress"},
+				WorkflowRun{ID: 103, RunNumber: 3, CreatedAt: createdAt, HeadBranch: "master", Status: "in_progress"},
+				WorkflowRun{ID: 102, RunNumber: 2, CreatedAt: createdAt, HeadBranch: "master", Status: "in_progress"},
+			})
+
+			if decisions[0].Run.ID != 103 || decisions[0].Cancel {
+				t.Fatalf("Run 103 should be kept")
+			}
+			if !decisions[1].Cancel || !decisions[2].Cancel {
+				t.Fatalf("Older runs should be cancelled")
+			}
+		}
+	})
+}
+
+func TestDecideActorRules(t *testing.T) {
+	start := time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC)
+	run := func(id int64, created time.Duration, status, login string) WorkflowRun {
+		return WorkflowRun{ID: id, CreatedAt: start.Add(created), HeadBranch: "deps", Status: status,
+			Actor: &Actor{Login: "someone"}, TriggeringActor: &Actor{Login: login}}
+	}
+	decide := func(policy *Policy, runs ...WorkflowRun) map[int64]string {
+		reasons := make(map[int64]string)
+		for _, decision := range policy.Decide(nil, runs) {
+			reasons[decision.Run.ID] = decision.Reason
+			if decision.Cancel {
+				reasons[decision.Run.ID] = "cancel"
+			}
+		}
+		return reasons
+	}
+
+	t.Run("Keep actors", func(t *testing.T) {
+		policy := MakeDefaultPolicy()
+		policy.KeepActors = []string{"release-bot[bot]"}
+
+		actual := decide(policy,
+			run(1, 0, "in_progress", "release-bot[bot]"),
+			run(2, time.Second, "in_progress", "octocat"),
+			run(3, 2*time.Second, "in_progress", "octocat"),
+		)
+
+		if actual[1] != "runs of release-bot[bot] are never cancelled" || actual[2] != "cancel" {
+			t.Errorf("Bad decisions: %v", actual)
+		}
+	})
+
+	t.Run("Aggressive actors skip debounce and status max age", func(t *testing.T) {
+		policy := MakeDefaultPolicy()
+		policy.Clock = &fakeClock{now: start.Add(5 * time.Second)}
+		policy.MinAge = time.Minute
+		policy.StatusRules = map[string]StatusRule{"in_progress": StatusRule{MaxAge: time.Second}}
+		policy.AggressiveActors = []string{"*[bot]"}
+
+		actual := decide(policy,
+			run(1, 0, "in_progress", "dependabot[bot]"),
+			run(2, time.Second, "in_progress", "octocat"),
+			run(3, 2*time.Second, "queued", "renovate[bot]"),
+		)
+
+		if actual[1] != "cancel" {
+			t.Errorf("Bot run should be cancelled: %v", actual)
+		}
+		if actual[2] != "created 4s ago, younger than 1m0s" {
+			t.Errorf("User run should be debounced: %v", actual)
+		}
+	})
+
+	t.Run("Aggressive actors keep never cancelled statuses", func(t *testing.T) {
+		policy := MakeDefaultPolicy()
+		policy.StatusRules = map[string]StatusRule{"in_progress": StatusRule{Never: true}}
+		policy.AggressiveActors = []string{"*[bot]"}
+
+		actual := decide(policy,
+			run(1, 0, "in_progress", "dependabot[bot]"),
+			run(2, time.Second, "queued", "renovate[bot]"),
+		)
+
+		if actual[1] != "status in_progress is never cancelled" {
+			t.Errorf("Bot run should be kept: %v", actual)
+		}
+	})
+
+	t.Run("Same actor only", func(t *testing.T) {
+		policy := MakeDefaultPolicy()
+		policy.SameActorOnly = true
+
+		actual := decide(policy,
+			run(1, 0, "in_progress", "octocat"),
+			run(2, time.Second, "in_progress", "hubot"),
+			run(3, 2*time.Second, "in_progress", "hubot"),
+		)
+
+		if actual[1] != "triggered by octocat, the newest run by hubot" || actual[2] != "cancel" {
+			t.Errorf("Bad decisions: %v", actual)
+		}
+	})
+
+	t.Run("Falls back to actor", func(t *testing.T) {
+		logins := WorkflowRun{Actor: &Actor{Login: "octocat"}}.Logins()
+
+		if !reflect.DeepEqual(logins, []string{"octocat"}) {
+			t.Errorf("Bad logins: %v", logins)
+		}
+	})
+}
+
+func TestDecideObsolete(t *testing.T) {
+	runs := []WorkflowRun{
+		WorkflowRun{ID: 1, HeadBranch: "feature", Status: "completed"},
+		WorkflowRun{ID: 2, HeadBranch: "feature", Status: "in_progress", CreatedAt: time.Unix(1, 0)},
+		WorkflowRun{ID: 3, HeadBranch: "feature", Status: "queued", CreatedAt: time.Unix(2, 0),
+			HeadCommit: &HeadCommit{Message: "Slow tests [no-cancel]"}},
+		WorkflowRun{ID: 4, HeadBranch: "feature", Status: "queued", CreatedAt: time.Unix(3, 0)},
+	}
+
+	decisions := MakeDefaultPolicy().DecideObsolete(&mockLabelAPI{}, runs, "pull request #7 was closed")
+
+	actual := make(map[int64]string)
+	for _, decision := range decisions {
+		if decision.Cancel {
+			actual[decision.Run.ID] = decision.Reason
+		} else {
+			actual[decision.Run.ID] = "keep"
+		}
+	}
+	expected := map[int64]string{
+		2: "pull request #7 was closed",
+		3: "keep",
+		4: "pull request #7 was closed",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Bad decisions: %v", actual)
+	}
+}
+
+func TestIsProtected(t *testing.T) {
+	policy := MakeDefaultPolicy()
+
+	if !policy.IsProtected("master") || !policy.IsProtected("main") || policy.IsProtected("feature") {
+		t.Errorf("Bad protected branches: %v", policy.ProtectedBranches)
+	}
+}
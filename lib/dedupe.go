@@ -0,0 +1,124 @@
+package lib
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DedupeStore remembers keys for a limited time, implement it to share the state between instances
+type DedupeStore interface {
+	// CheckAndSet marks the key for ttl and reports whether it was already marked
+	CheckAndSet(key string, ttl time.Duration) (bool, error)
+	// Release forgets the key so it can be marked again
+	Release(key string) error
+}
+
+// MemoryDedupeStore is a DedupeStore local to the process
+type MemoryDedupeStore struct {
+	Clock Clock
+
+	mu      sync.Mutex
+	expires map[string]time.Time
+}
+
+// MakeMemoryDedupeStore creates an in-memory store using the wall clock
+func MakeMemoryDedupeStore() *MemoryDedupeStore {
+	return &MemoryDedupeStore{Clock: RealClock}
+}
+
+// CheckAndSet marks the key for ttl and reports whether it was already marked
+func (store *MemoryDedupeStore) CheckAndSet(key string, ttl time.Duration) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := store.Clock.Now()
+	if store.expires == nil {
+		store.expires = make(map[string]time.Time)
+	}
+	for storedKey, expiry := range store.expires {
+		if !expiry.After(now) {
+			delete(store.expires, storedKey)
+		}
+	}
+
+	_, seen := store.expires[key]
+	if !seen {
+		store.expires[key] = now.Add(ttl)
+	}
+
+	return seen, nil
+}
+
+// Release forgets the key so it can be marked again
+func (store *MemoryDedupeStore) Release(key string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	delete(store.expires, key)
+	return nil
+}
+
+// Deduplicator skips redelivered webhooks and coalesces bursts of events for the same commit
+type Deduplicator struct {
+	Store          DedupeStore
+	DeliveryTTL    time.Duration
+	CoalesceWindow time.Duration
+}
+
+// MakeDeduplicator creates a deduplicator with an in-memory store
+func MakeDeduplicator(deliveryTTL, coalesceWindow time.Duration) *Deduplicator {
+	return &Deduplicator{
+		Store:          MakeMemoryDedupeStore(),
+		DeliveryTTL:    deliveryTTL,
+		CoalesceWindow: coalesceWindow,
+	}
+}
+
+func deliveryKey(deliveryID string) string {
+	return "delivery:" + deliveryID
+}
+
+func commitDedupeKey(repository, headSHA string) string {
+	return "commit:" + repository + "@" + headSHA
+}
+
+// ShouldSkip reports whether the delivery was already handled, empty ids are never deduplicated
+func (dedupe *Deduplicator) ShouldSkip(deliveryID, repository, headSHA string) (bool, string, error) {
+	if deliveryID != "" && dedupe.DeliveryTTL > 0 {
+		seen, err := dedupe.Store.CheckAndSet(deliveryKey(deliveryID), dedupe.DeliveryTTL)
+		if err != nil {
+			return false, "", err
+		}
+		if seen {
+			return true, fmt.Sprintf("Duplicate delivery %s", deliveryID), nil
+		}
+	}
+
+	if repository != "" && headSHA != "" && dedupe.CoalesceWindow > 0 {
+		seen, err := dedupe.Store.CheckAndSet(commitDedupeKey(repository, headSHA), dedupe.CoalesceWindow)
+		if err != nil {
+			return false, "", err
+		}
+		if seen {
+			return true, fmt.Sprintf("Coalesced with a recent delivery for %s@%s", repository, headSHA), nil
+		}
+	}
+
+	return false, "", nil
+}
+
+// Release forgets a delivery which failed, so its redelivery and the next delivery for the commit are handled
+func (dedupe *Deduplicator) Release(deliveryID, repository, headSHA string) error {
+	if deliveryID != "" {
+		err := dedupe.Store.Release(deliveryKey(deliveryID))
+		if err != nil {
+			return err
+		}
+	}
+	if repository != "" && headSHA != "" {
+		return dedupe.Store.Release(commitDedupeKey(repository, headSHA))
+	}
+
+	return nil
+}
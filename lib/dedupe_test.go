@@ -0,0 +1,99 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryDedupeStore(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC)}
+	store := &MemoryDedupeStore{Clock: clock}
+
+	seen, _ := store.CheckAndSet("key", time.Minute)
+	if seen {
+		t.Errorf("New key should not be seen")
+	}
+
+	clock.now = clock.now.Add(30 * time.Second)
+	seen, _ = store.CheckAndSet("key", time.Minute)
+	if !seen {
+		t.Errorf("Key should be seen within the ttl")
+	}
+
+	clock.now = clock.now.Add(30 * time.Second)
+	seen, _ = store.CheckAndSet("key", time.Minute)
+	if seen {
+		t.Errorf("Key should expire after the ttl")
+	}
+}
+
+func TestDeduplicator(t *testing.T) {
+	makeDeduplicator := func(clock *fakeClock) *Deduplicator {
+		return &Deduplicator{
+			Store:          &MemoryDedupeStore{Clock: clock},
+			DeliveryTTL:    5 * time.Minute,
+			CoalesceWindow: 10 * time.Second,
+		}
+	}
+
+	t.Run("Skips redelivery", func(t *testing.T) {
+		dedupe := makeDeduplicator(&fakeClock{now: time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC)})
+
+		skip, _, _ := dedupe.ShouldSkip("guid-1", "", "")
+		if skip {
+			t.Errorf("First delivery should not be skipped")
+		}
+		skip, reason, _ := dedupe.ShouldSkip("guid-1", "", "")
+		if !skip || reason != "Duplicate delivery guid-1" {
+			t.Errorf("Redelivery should be skipped, reason: %s", reason)
+		}
+	})
+
+	t.Run("Coalesces events for the same commit", func(t *testing.T) {
+		clock := &fakeClock{now: time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC)}
+		dedupe := makeDeduplicator(clock)
+
+		skip, _, _ := dedupe.ShouldSkip("push-guid", "org/repo", "abc")
+		if skip {
+			t.Errorf("First event should not be skipped")
+		}
+		skip, reason, _ := dedupe.ShouldSkip("pull-request-guid", "org/repo", "abc")
+		if !skip || reason != "Coalesced with a recent delivery for org/repo@abc" {
+			t.Errorf("Second event should be coalesced, reason: %s", reason)
+		}
+		skip, _, _ = dedupe.ShouldSkip("other-repo-guid", "org/other", "abc")
+		if skip {
+			t.Errorf("Other repository should not be coalesced")
+		}
+
+		clock.now = clock.now.Add(10 * time.Second)
+		skip, _, _ = dedupe.ShouldSkip("workflow-run-guid", "org/repo", "abc")
+		if skip {
+			t.Errorf("Event after the window should not be skipped")
+		}
+	})
+
+	t.Run("Released deliveries are handled again", func(t *testing.T) {
+		dedupe := makeDeduplicator(&fakeClock{now: time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC)})
+
+		dedupe.ShouldSkip("guid-1", "org/repo", "abc")
+		err := dedupe.Release("guid-1", "org/repo", "abc")
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		skip, reason, _ := dedupe.ShouldSkip("guid-1", "org/repo", "abc")
+		if skip {
+			t.Errorf("Redelivery of a failed delivery should not be skipped, reason: %s", reason)
+		}
+	})
+
+	t.Run("Missing ids are never skipped", func(t *testing.T) {
+		dedupe := makeDeduplicator(&fakeClock{now: time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC)})
+
+		dedupe.ShouldSkip("", "", "")
+		skip, _, _ := dedupe.ShouldSkip("", "", "")
+		if skip {
+			t.Errorf("Delivery without ids should not be skipped")
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package lib
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+type emfDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+type emfMetadata struct {
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+// EMFMetrics writes every value as a CloudWatch Embedded Metric Format record,
+// CloudWatch extracts the metrics from the Lambda logs
+type EMFMetrics struct {
+	Writer    io.Writer
+	Namespace string
+	Now       func() time.Time
+
+	mu sync.Mutex
+}
+
+// MakeEMFMetrics creates the sink writing the records of the namespace
+func MakeEMFMetrics(writer io.Writer, namespace string) *EMFMetrics {
+	return &EMFMetrics{Writer: writer, Namespace: namespace, Now: time.Now}
+}
+
+// Put writes a record with the value, values with more dimensions than the Repository are also
+// rolled up per Repository, since CloudWatch only aggregates over the exact dimension sets
+func (emf *EMFMetrics) Put(name string, value float64, unit string, dimensions Dimensions) {
+	keys := make([]string, 0, len(dimensions))
+	for key := range dimensions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	dimensionSets := [][]string{keys}
+	if _, ok := dimensions["Repository"]; ok && len(keys) > 1 {
+		dimensionSets = [][]string{[]string{"Repository"}, keys}
+	}
+
+	record := make(map[string]interface{}, len(dimensions)+2)
+	for key, dimension := range dimensions {
+		record[key] = dimension
+	}
+	record[name] = value
+	record["_aws"] = emfMetadata{
+		Timestamp: emf.Now().UnixNano() / int64(time.Millisecond),
+		CloudWatchMetrics: []emfDirective{{
+			Namespace:  emf.Namespace,
+			Dimensions: dimensionSets,
+			Metrics:    []emfMetric{{Name: name, Unit: unit}},
+		}},
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		DefaultLogger().Warn("Encoding metric failed", Fields{"metric": name, "error": err})
+		return
+	}
+
+	emf.mu.Lock()
+	defer emf.mu.Unlock()
+	emf.Writer.Write(append(line, '\n'))
+}
@@ -0,0 +1,44 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestEMFMetrics(t *testing.T) {
+	output := &bytes.Buffer{}
+	metrics := MakeEMFMetrics(output, "ActionsAutomaticCancel")
+	metrics.Now = func() time.Time {
+		return time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC)
+	}
+
+	metrics.Put(MetricRunsCancelled, 2, UnitCount, Dimensions{"Workflow": "CI", "Repository": "org/repo"})
+
+	expected := `{"Repository":"org/repo","RunsCancelled":2,"Workflow":"CI","_aws":{"Timestamp":1582934400000,` +
+		`"CloudWatchMetrics":[{"Namespace":"ActionsAutomaticCancel","Dimensions":[["Repository"],["Repository","Workflow"]],` +
+		`"Metrics":[{"Name":"RunsCancelled","Unit":"Count"}]}]}}` + "\n"
+	if output.String() != expected {
+		t.Errorf("Bad record: %s", output.String())
+	}
+
+	t.Run("Writes one record per line", func(t *testing.T) {
+		output.Reset()
+
+		metrics.Put(MetricGitHubAPILatency, 12.5, UnitMilliseconds, nil)
+		metrics.Put(MetricRunsListed, 3, UnitCount, nil)
+
+		lines := readLogLines(t, output)
+		if len(lines) != 2 || lines[0][MetricGitHubAPILatency] != 12.5 || lines[1][MetricRunsListed] != float64(3) {
+			t.Errorf("Bad records: %v", lines)
+		}
+		var record struct {
+			AWS emfMetadata `json:"_aws"`
+		}
+		json.Unmarshal([]byte(bytes.SplitN(output.Bytes(), []byte("\n"), 2)[0]), &record)
+		if len(record.AWS.CloudWatchMetrics) != 1 || len(record.AWS.CloudWatchMetrics[0].Dimensions[0]) != 0 {
+			t.Errorf("Bad metadata: %v", record.AWS)
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package lib
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// ETagEntry is a cached response body with its ETag
+type ETagEntry struct {
+	ETag string
+	Body []byte
+}
+
+// ETagStore keeps the last response of endpoints, implement it to share the responses between instances
+type ETagStore interface {
+	Get(key string) (ETagEntry, bool)
+	Set(key string, entry ETagEntry)
+}
+
+// LRUETagStore is an ETagStore local to the process which evicts the least recently used entries
+type LRUETagStore struct {
+	Size int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type lruItem struct {
+	key   string
+	entry ETagEntry
+}
+
+// MakeLRUETagStore creates an in-memory store holding at most size entries
+func MakeLRUETagStore(size int) *LRUETagStore {
+	return &LRUETagStore{Size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// Get returns the entry of the key and marks it as recently used
+func (store *LRUETagStore) Get(key string) (ETagEntry, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	element, ok := store.entries[key]
+	if !ok {
+		return ETagEntry{}, false
+	}
+	store.order.MoveToFront(element)
+	return element.Value.(*lruItem).entry, true
+}
+
+// Set stores the entry, evicting the least recently used one when the store is full
+func (store *LRUETagStore) Set(key string, entry ETagEntry) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if element, ok := store.entries[key]; ok {
+		element.Value.(*lruItem).entry = entry
+		store.order.MoveToFront(element)
+		return
+	}
+
+	store.entries[key] = store.order.PushFront(&lruItem{key: key, entry: entry})
+	for store.order.Len() > store.Size {
+		oldest := store.order.Back()
+		store.order.Remove(oldest)
+		delete(store.entries, oldest.Value.(*lruItem).key)
+	}
+}
+
+// ETagStats counts the conditional requests, a hit is a 304 served from the cache
+type ETagStats struct {
+	Hits   int64
+	Misses int64
+}
+
+// HitRate returns the share of requests served from the cache
+func (stats ETagStats) HitRate() float64 {
+	if stats.Hits+stats.Misses == 0 {
+		return 0
+	}
+
+	return float64(stats.Hits) / float64(stats.Hits+stats.Misses)
+}
+
+// ETagCache sends conditional requests for the cached endpoints, 304 responses don't count against the rate limit
+type ETagCache struct {
+	Store ETagStore
+
+	hits   int64
+	misses int64
+}
+
+// MakeETagCache creates a cache with an in-memory LRU store of size entries
+func MakeETagCache(size int) *ETagCache {
+	return &ETagCache{Store: MakeLRUETagStore(size)}
+}
+
+// Stats returns the hits and misses so far
+func (cache *ETagCache) Stats() ETagStats {
+	return ETagStats{Hits: atomic.LoadInt64(&cache.hits), Misses: atomic.LoadInt64(&cache.misses)}
+}
+
+func (cache *ETagCache) hit() {
+	atomic.AddInt64(&cache.hits, 1)
+}
+
+func (cache *ETagCache) miss() {
+	atomic.AddInt64(&cache.misses, 1)
+}
+
+// ETagStatsReporter is implemented by apis with an ETag cache
+type ETagStatsReporter interface {
+	ETagStats() ETagStats
+}
@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"net/http"
+	"testing"
+
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestLRUETagStore(t *testing.T) {
+	store := MakeLRUETagStore(2)
+	store.Set("a", ETagEntry{ETag: "1"})
+	store.Set("b", ETagEntry{ETag: "2"})
+	store.Get("a")
+	store.Set("c", ETagEntry{ETag: "3"})
+
+	if _, ok := store.Get("b"); ok {
+		t.Errorf("Least recently used entry should be evicted")
+	}
+	if entry, ok := store.Get("a"); !ok || entry.ETag != "1" {
+		t.Errorf("Bad entry: %+v %t", entry, ok)
+	}
+
+	store.Set("c", ETagEntry{ETag: "4"})
+	if entry, _ := store.Get("c"); entry.ETag != "4" {
+		t.Errorf("Entry should be replaced: %+v", entry)
+	}
+}
+
+func TestListWorkflowsETag(t *testing.T) {
+	defer gock.Off()
+	metrics := &recordingMetrics{}
+	githubAPI := GithubAPI{Organization: "org", Repository: "repo", Token: "dummytoken", ETags: MakeETagCache(10), Client: gockClient, Metrics: metrics}
+	body := `{"total_count":1,"workflow_runs":[{"id":1,"status":"queued"}]}`
+
+	gock.New("https://api.github.com").
+		Get("/repos/org/repo/actions/runs").
+		Reply(http.StatusOK).
+		SetHeader("ETag", `W/"abc"`).
+		BodyString(body)
+	gock.New("https://api.github.com").
+		Get("/repos/org/repo/actions/runs").
+		MatchHeader("If-None-Match", `W/"abc"`).
+		Reply(http.StatusNotModified)
+
+	for i := 0; i < 2; i++ {
+		runs, err := githubAPI.ListWorkflows()
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		if len(runs) != 1 || runs[0].ID != 1 {
+			t.Errorf("Bad runs: %v", runs)
+		}
+	}
+
+	if !gock.IsDone() {
+		t.Errorf("Endpoinds was not called")
+	}
+	stats := githubAPI.ETagStats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.HitRate() != 0.5 {
+		t.Errorf("Bad stats: %+v", stats)
+	}
+	hits, misses := metrics.named(MetricETagHits), metrics.named(MetricETagMisses)
+	if len(hits) != 1 || len(misses) != 1 || hits[0].Dimensions["Repository"] != "org/repo" {
+		t.Errorf("Bad metrics: %v", metrics.values)
+	}
+}
+
+func TestListWorkflowsErrorStatus(t *testing.T) {
+	defer gock.Off()
+	githubAPI := GithubAPI{Organization: "org", Repository: "repo", Token: "dummytoken", ETags: MakeETagCache(10), Client: gockClient}
+
+	gock.New("https://api.github.com").
+		Get("/repos/org/repo/actions/runs").
+		Reply(http.StatusForbidden).
+		SetHeader("ETag", `W/"abc"`).
+		BodyString(`{"message":"API rate limit exceeded"}`)
+
+	runs, err := githubAPI.ListWorkflows()
+
+	statusErr, ok := err.(*StatusError)
+	if !ok || statusErr.StatusCode != http.StatusForbidden || runs != nil {
+		t.Errorf("Bad error: %v %v", runs, err)
+	}
+	if _, cached := githubAPI.ETags.Store.Get("https://api.github.com/repos/org/repo/actions/runs"); cached {
+		t.Errorf("Error responses should not be cached")
+	}
+}
@@ -0,0 +1,180 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const giteaListRunsEndpointFormat = "%s/api/v1/repos/%s/%s/actions/runs"
+const giteaCancelRunEndpointFormat = "%s/api/v1/repos/%s/%s/actions/runs/%d/cancel"
+const giteaPageSize = 50
+
+// giteaActiveStatuses are the statuses of the runs which can still be cancelled
+var giteaActiveStatuses = []string{"queued", "waiting", "in_progress"}
+
+// giteaRun is a run of the Gitea and Forgejo actions api
+type giteaRun struct {
+	ID           int64     `json:"id"`
+	RunNumber    int64     `json:"run_number"`
+	RunAttempt   int64     `json:"run_attempt"`
+	DisplayTitle string    `json:"display_title"`
+	Path         string    `json:"path"`
+	Event        string    `json:"event"`
+	HeadBranch   string    `json:"head_branch"`
+	HeadSHA      string    `json:"head_sha"`
+	Status       string    `json:"status"`
+	StartedAt    time.Time `json:"started_at"`
+	Actor        *Actor    `json:"actor,omitempty"`
+	TriggerActor *Actor    `json:"trigger_actor,omitempty"`
+}
+
+type giteaRunAPIResponse struct {
+	TotalCount   int64      `json:"total_count"`
+	WorkflowRuns []giteaRun `json:"workflow_runs"`
+}
+
+// GiteaAPI lists and cancels the action runs of a Gitea or Forgejo repository
+type GiteaAPI struct {
+	BaseURL      string
+	Organization string
+	Repository   string
+	Token        string
+	Client       *http.Client
+}
+
+// MakeGiteaAPI creates the api for the instance at baseURL, like https://codeberg.org
+func MakeGiteaAPI(baseURL, organization, repository, token string) *GiteaAPI {
+	return &GiteaAPI{
+		BaseURL:      strings.TrimSuffix(baseURL, "/"),
+		Organization: organization,
+		Repository:   repository,
+		Token:        token,
+		Client:       defaultHTTPClient,
+	}
+}
+
+func (api *GiteaAPI) request(method, endpoint string) ([]byte, error) {
+	return sendRequest(api.Client, method, endpoint, http.Header{"Authorization": []string{"token " + api.Token}})
+}
+
+// GroupKey groups the runs by branch
+func (api *GiteaAPI) GroupKey(run WorkflowRun) string {
+	return branchKey(run)
+}
+
+// ListWorkflows returns list of workflows
+func (api *GiteaAPI) ListWorkflows() ([]WorkflowRun, error) {
+	return api.listRuns(url.Values{})
+}
+
+// ListBranchWorkflows returns list of workflows of a branch
+func (api *GiteaAPI) ListBranchWorkflows(branch string) ([]WorkflowRun, error) {
+	return api.listRuns(url.Values{"branch": []string{branch}})
+}
+
+// listRuns lists every page of the active runs, one status at a time
+func (api *GiteaAPI) listRuns(query url.Values) ([]WorkflowRun, error) {
+	var runs []giteaRun
+	seen := make(map[int64]bool)
+	for _, status := range giteaActiveStatuses {
+		query.Set("status", status)
+		statusRuns, err := api.listPages(query)
+		if err != nil {
+			return nil, err
+		}
+		for _, run := range statusRuns {
+			// A run changing its status between two listings is listed twice
+			if !seen[run.ID] {
+				seen[run.ID] = true
+				runs = append(runs, run)
+			}
+		}
+	}
+
+	return api.runsOf(runs), nil
+}
+
+func (api *GiteaAPI) listPages(query url.Values) ([]giteaRun, error) {
+	query.Set("limit", strconv.Itoa(giteaPageSize))
+	endpoint := fmt.Sprintf(giteaListRunsEndpointFormat, api.BaseURL, api.Organization, api.Repository)
+	var runs []giteaRun
+	for page := 1; ; page++ {
+		query.Set("page", strconv.Itoa(page))
+		body, err := api.request("GET", endpoint+"?"+query.Encode())
+		if err != nil {
+			return nil, err
+		}
+
+		runsRes := giteaRunAPIResponse{}
+		err = json.Unmarshal(body, &runsRes)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, runsRes.WorkflowRuns...)
+		if len(runsRes.WorkflowRuns) < giteaPageSize || int64(len(runs)) >= runsRes.TotalCount {
+			return runs, nil
+		}
+	}
+}
+
+// runsOf converts the runs in the order they were created. Gitea has no creation time and queued runs
+// haven't started, so a run is dated by its start but never before a run with a lower id.
+func (api *GiteaAPI) runsOf(giteaRuns []giteaRun) []WorkflowRun {
+	sort.Slice(giteaRuns, func(i, j int) bool {
+		return giteaRuns[i].ID < giteaRuns[j].ID
+	})
+
+	runs := make([]WorkflowRun, 0, len(giteaRuns))
+	var previous time.Time
+	for _, giteaRun := range giteaRuns {
+		run := api.runOf(giteaRun)
+		if !run.CreatedAt.After(previous) && len(runs) > 0 {
+			run.CreatedAt = previous.Add(time.Nanosecond)
+		}
+		previous = run.CreatedAt
+		runs = append(runs, run)
+	}
+
+	return runs
+}
+
+// runOf converts the run, Gitea reports the workflow file as path@ref and has no creation time.
+// The run is dated by its start, which is the zero time for runs that haven't started.
+// The name of the workflow isn't reported either, the file name like ci.yml stands in for it.
+func (api *GiteaAPI) runOf(run giteaRun) WorkflowRun {
+	workflowPath := run.Path
+	if at := strings.Index(workflowPath, "@"); at >= 0 {
+		workflowPath = workflowPath[:at]
+	}
+
+	return WorkflowRun{
+		ID:              run.ID,
+		RunNumber:       run.RunNumber,
+		Name:            path.Base(workflowPath),
+		Path:            workflowPath,
+		Event:           run.Event,
+		CreatedAt:       run.StartedAt,
+		RunStartedAt:    run.StartedAt,
+		HeadBranch:      run.HeadBranch,
+		HeadSHA:         run.HeadSHA,
+		RunAttempt:      run.RunAttempt,
+		Status:          run.Status,
+		CancelURL:       fmt.Sprintf(giteaCancelRunEndpointFormat, api.BaseURL, api.Organization, api.Repository, run.ID),
+		HeadCommit:      &HeadCommit{ID: run.HeadSHA, Message: run.DisplayTitle},
+		Actor:           run.Actor,
+		TriggeringActor: run.TriggerActor,
+	}
+}
+
+// CancelRun cancels a running workflow
+func (api *GiteaAPI) CancelRun(run WorkflowRun) error {
+	_, err := api.request("POST", run.CancelURL)
+	return err
+}
@@ -0,0 +1,105 @@
+package lib
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGiteaAPI(t *testing.T) {
+	inProgress := `{"total_count":1,"workflow_runs":[
+		{"id":1,"run_number":3,"run_attempt":1,"display_title":"Fix","path":"ci.yml@refs/heads/feature","event":"push",
+		 "head_branch":"feature","head_sha":"a","status":"in_progress","started_at":"2020-02-29T00:00:00Z",
+		 "trigger_actor":{"login":"octocat"}}
+	]}`
+	queued := `{"total_count":1,"workflow_runs":[
+		{"id":2,"run_number":4,"path":"ci.yml@refs/heads/feature","head_branch":"feature","head_sha":"b",
+		 "status":"queued","started_at":"1970-01-01T00:00:00Z"}
+	]}`
+	gitea := &fakeForge{header: "Authorization", token: "token dummytoken", routes: map[string]string{
+		"GET /api/v1/repos/org/repo/actions/runs":                                    `{"total_count":0,"workflow_runs":[]}`,
+		"GET /api/v1/repos/org/repo/actions/runs?limit=50&page=1&status=in_progress": inProgress,
+		"GET /api/v1/repos/org/repo/actions/runs?limit=50&page=1&status=queued":      queued,
+		"POST /api/v1/repos/org/repo/actions/runs/1/cancel":                          "",
+	}}
+	server := httptest.NewServer(gitea)
+	defer server.Close()
+	api := MakeGiteaAPI(server.URL+"/", "org", "repo", "dummytoken")
+
+	t.Run("List runs", func(t *testing.T) {
+		runs, err := api.ListWorkflows()
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+
+		expected := WorkflowRun{
+			ID:              1,
+			RunNumber:       3,
+			Name:            "ci.yml",
+			Path:            "ci.yml",
+			Event:           "push",
+			CreatedAt:       time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC),
+			RunStartedAt:    time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC),
+			HeadBranch:      "feature",
+			HeadSHA:         "a",
+			RunAttempt:      1,
+			Status:          "in_progress",
+			CancelURL:       server.URL + "/api/v1/repos/org/repo/actions/runs/1/cancel",
+			HeadCommit:      &HeadCommit{ID: "a", Message: "Fix"},
+			TriggeringActor: &Actor{Login: "octocat"},
+		}
+		if len(runs) != 2 || !reflect.DeepEqual(runs[0], expected) {
+			t.Errorf("Bad runs: %+v", runs)
+		}
+		if !runs[1].CreatedAt.After(runs[0].CreatedAt) {
+			t.Errorf("Queued run should be newer: %v", runs[1].CreatedAt)
+		}
+	})
+
+	t.Run("List runs of a branch", func(t *testing.T) {
+		gitea.requests = nil
+
+		_, err := ListBranchWorkflows(api, "feature/x")
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		if len(gitea.requests) != 3 || gitea.requests[0] != "GET /api/v1/repos/org/repo/actions/runs?branch=feature%2Fx&limit=50&page=1&status=queued" {
+			t.Errorf("Bad requests: %v", gitea.requests)
+		}
+	})
+
+	t.Run("Follows the pages", func(t *testing.T) {
+		page := make([]string, 0, giteaPageSize)
+		for i := 0; i < giteaPageSize; i++ {
+			page = append(page, fmt.Sprintf(`{"id":%d,"path":"ci.yml@refs/heads/main","head_branch":"main","status":"queued"}`, 100+i))
+		}
+		gitea.routes["GET /api/v1/repos/org/repo/actions/runs?branch=main&limit=50&page=1&status=queued"] = `{"total_count":51,"workflow_runs":[` + strings.Join(page, ",") + `]}`
+		gitea.routes["GET /api/v1/repos/org/repo/actions/runs?branch=main&limit=50&page=2&status=queued"] = `{"total_count":51,"workflow_runs":[{"id":99,"path":"ci.yml@refs/heads/main","head_branch":"main","status":"queued"}]}`
+		gitea.requests = nil
+
+		runs, err := ListBranchWorkflows(api, "main")
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		if len(runs) != giteaPageSize+1 || runs[0].ID != 99 || len(gitea.requests) != 4 {
+			t.Errorf("Bad runs: %v %v", runs, gitea.requests)
+		}
+	})
+
+	t.Run("Cancels the superseded run", func(t *testing.T) {
+		gitea.requests = nil
+		runs, _ := api.ListWorkflows()
+
+		err := MakeDefaultPolicy().AutomaticCancel(api, runs)
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+
+		if len(gitea.requests) != 4 || gitea.requests[3] != "POST /api/v1/repos/org/repo/actions/runs/1/cancel?" {
+			t.Errorf("Bad requests: %v", gitea.requests)
+		}
+	})
+}
@@ -3,19 +3,76 @@ package lib
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// HeadCommit struct
+type HeadCommit struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+}
+
+// Actor struct
+type Actor struct {
+	Login string `json:"login"`
+}
+
+// Repository struct
+type Repository struct {
+	FullName string `json:"full_name"`
+}
+
+// PullRequest struct
+type PullRequest struct {
+	Number int64 `json:"number"`
+	Head   struct {
+		Ref string `json:"ref"`
+		SHA string `json:"sha"`
+	} `json:"head"`
+}
+
 // WorkflowRun struct
 type WorkflowRun struct {
-	ID         int64     `json:"id"`
-	CreatedAt  time.Time `json:"created_at"`
-	HeadBranch string    `json:"head_branch"`
-	Status     string    `json:"status"`
-	CancelURL  string    `json:"cancel_url"`
+	ID              int64         `json:"id"`
+	RunNumber       int64         `json:"run_number"`
+	Name            string        `json:"name"`
+	Path            string        `json:"path"`
+	Event           string        `json:"event"`
+	CreatedAt       time.Time     `json:"created_at"`
+	RunStartedAt    time.Time     `json:"run_started_at"`
+	HeadBranch      string        `json:"head_branch"`
+	HeadSHA         string        `json:"head_sha"`
+	RunAttempt      int64         `json:"run_attempt"`
+	Status          string        `json:"status"`
+	CancelURL       string        `json:"cancel_url"`
+	HeadRepository  *Repository   `json:"head_repository,omitempty"`
+	HeadCommit      *HeadCommit   `json:"head_commit,omitempty"`
+	Actor           *Actor        `json:"actor,omitempty"`
+	TriggeringActor *Actor        `json:"triggering_actor,omitempty"`
+	PullRequests    []PullRequest `json:"pull_requests,omitempty"`
+}
+
+// Logins returns the login of the triggering actor first and then of the actor
+func (run WorkflowRun) Logins() []string {
+	var logins []string
+	if run.TriggeringActor != nil && run.TriggeringActor.Login != "" {
+		logins = append(logins, run.TriggeringActor.Login)
+	}
+	if run.Actor != nil && run.Actor.Login != "" {
+		logins = append(logins, run.Actor.Login)
+	}
+
+	return logins
+}
+
+// Label struct
+type Label struct {
+	Name string `json:"name"`
 }
 
 // WorkflowRunAPIResponse struct
@@ -24,10 +81,65 @@ type WorkflowRunAPIResponse struct {
 	WorkflowRuns []WorkflowRun `json:"workflow_runs"`
 }
 
-// IGithubAPI interface
-type IGithubAPI interface {
-	ListWorkflows() ([]WorkflowRun, error)
-	CancelRun(run WorkflowRun) error
+// StatusError is returned when the api responds with an unexpected status code
+type StatusError struct {
+	StatusCode int
+	Body       []byte
+}
+
+func (err *StatusError) Error() string {
+	return fmt.Sprintf("Bad status code: %d \nBody: %s", err.StatusCode, err.Body)
+}
+
+// IsNotFound reports whether the api responded with 404
+func IsNotFound(err error) bool {
+	statusErr, ok := err.(*StatusError)
+	return ok && statusErr.StatusCode == http.StatusNotFound
+}
+
+type branchAPIResponse struct {
+	Commit struct {
+		SHA string `json:"sha"`
+	} `json:"commit"`
+}
+
+type compareAPIResponse struct {
+	Status string `json:"status"`
+}
+
+type pullRequestAPIResponse struct {
+	Merged bool `json:"merged"`
+}
+
+// BranchWorkflowLister is implemented by apis which can list the runs of a single branch
+type BranchWorkflowLister interface {
+	ListBranchWorkflows(branch string) ([]WorkflowRun, error)
+}
+
+// ListBranchWorkflows lists the runs of a branch, filtering them locally when the api can't
+func ListBranchWorkflows(api Provider, branch string) ([]WorkflowRun, error) {
+	if lister, ok := api.(BranchWorkflowLister); ok {
+		return lister.ListBranchWorkflows(branch)
+	}
+
+	runs, err := api.ListWorkflows()
+	if err != nil {
+		return nil, err
+	}
+
+	var filtered []WorkflowRun
+	for _, run := range runs {
+		if run.HeadBranch == branch {
+			filtered = append(filtered, run)
+		}
+	}
+
+	return filtered, nil
+}
+
+// OpenPullRequestLister is implemented by apis which can list the open pull requests of a branch
+type OpenPullRequestLister interface {
+	ListOpenPullRequests(headRepository, branch string) ([]int64, error)
 }
 
 // GithubAPI struct
@@ -35,9 +147,21 @@ type GithubAPI struct {
 	Organization string
 	Repository   string
 	Token        string
+	// Client sends the requests, a client with DefaultHTTPTimeout is used when nil
+	Client *http.Client
+	// ETags makes the run listings conditional requests when not nil
+	ETags *ETagCache
+	// Metrics receives the latency and errors of every request when not nil
+	Metrics Metrics
 }
 
 const listRunsEndpointFormat = "https://api.github.com/repos/%s/%s/actions/runs"
+const listLabelsEndpointFormat = "https://api.github.com/repos/%s/%s/issues/%d/labels"
+const branchEndpointFormat = "https://api.github.com/repos/%s/%s/branches/%s"
+const compareEndpointFormat = "https://api.github.com/repos/%s/%s/compare/%s...%s"
+const pullRequestEndpointFormat = "https://api.github.com/repos/%s/%s/pulls/%d"
+const listOpenPullRequestsEndpointFormat = "https://api.github.com/repos/%s/%s/pulls?state=open&head=%s"
+const listJobsEndpointFormat = "https://api.github.com/repos/%s/%s/actions/runs/%d/jobs?per_page=100&page=%d"
 
 // MakeGithubAPI creates the api
 func MakeGithubAPI() *GithubAPI {
@@ -45,61 +169,280 @@ func MakeGithubAPI() *GithubAPI {
 		Organization: os.Getenv("GITHUB_ORG"),
 		Repository:   os.Getenv("GITHUB_REPO"),
 		Token:        os.Getenv("GITHUB_TOKEN"),
+		Client:       defaultHTTPClient,
+	}
+}
+
+// MakeGithubAPIFor creates the api for an org/name repository
+func MakeGithubAPIFor(repository string) (*GithubAPI, error) {
+	parts := strings.Split(repository, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("Bad repository format: %s", repository)
+	}
+
+	return &GithubAPI{
+		Organization: parts[0],
+		Repository:   parts[1],
+		Token:        os.Getenv("GITHUB_TOKEN"),
+		Client:       defaultHTTPClient,
+	}, nil
+}
+
+// APIFromEnv returns the Gitea api for the repository when GITEA_URL is set,
+// the GraphQL api when GITHUB_API is graphql and the REST api otherwise.
+// GRAPHQL_REFS and GRAPHQL_COMMITS limit the most recently committed branches and the commits per branch listed.
+// The GraphQL api is refused with actor rules, since it doesn't know who triggered a run.
+func APIFromEnv(api *GithubAPI) (Provider, error) {
+	if baseURL := os.Getenv("GITEA_URL"); baseURL != "" {
+		gitea := MakeGiteaAPI(baseURL, api.Organization, api.Repository, api.Token)
+		gitea.Client = api.Client
+		return gitea, nil
+	}
+	if os.Getenv("GITHUB_API") != "graphql" {
+		return api, nil
+	}
+	for _, name := range []string{"KEEP_ACTORS", "AGGRESSIVE_ACTORS", "SAME_ACTOR_ONLY"} {
+		if os.Getenv(name) != "" {
+			return nil, fmt.Errorf("Bad %s: GITHUB_API=graphql doesn't expose the triggering actor of a run", name)
+		}
+	}
+
+	graphQL := MakeGraphQLAPI(api)
+	if refs, err := strconv.Atoi(os.Getenv("GRAPHQL_REFS")); err == nil && refs > 0 {
+		graphQL.Refs = refs
+	}
+	if commits, err := strconv.Atoi(os.Getenv("GRAPHQL_COMMITS")); err == nil && commits > 0 {
+		graphQL.Commits = commits
+	}
+	return graphQL, nil
+}
+
+// do sends the request, endpoint names the request in the metrics
+func (api *GithubAPI) do(endpoint string, req *http.Request) (*http.Response, error) {
+	req.Header.Add("Authorization", "token "+api.Token)
+	start := time.Now()
+	res, err := clientOrDefault(api.Client).Do(req)
+	api.measure(endpoint, time.Since(start), res, err)
+	return res, err
+}
+
+func (api *GithubAPI) measure(endpoint string, latency time.Duration, res *http.Response, err error) {
+	if api.Metrics == nil {
+		return
+	}
+
+	repository := api.Organization + "/" + api.Repository
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(res.StatusCode)
+	}
+	dimensions := Dimensions{"Repository": repository, "Endpoint": endpoint, "Status": status}
+	api.Metrics.Put(MetricGitHubAPILatency, Milliseconds(latency), UnitMilliseconds, dimensions)
+	if err != nil || res.StatusCode >= http.StatusBadRequest {
+		api.Metrics.Put(MetricGitHubAPIErrors, 1, UnitCount, dimensions)
+	}
+	if err != nil {
+		return
 	}
+
+	// Every response reports the requests left from the rate limit of its resource, like core or graphql
+	remaining, parseErr := strconv.Atoi(res.Header.Get("X-RateLimit-Remaining"))
+	if parseErr != nil {
+		return
+	}
+	resource := res.Header.Get("X-RateLimit-Resource")
+	if resource == "" {
+		resource = "core"
+	}
+	api.Metrics.Put(MetricGitHubRateLimit, float64(remaining), UnitCount, Dimensions{"Repository": repository, "Resource": resource})
 }
 
 // CancelRun cancels a running workflow
 func (api *GithubAPI) CancelRun(run WorkflowRun) error {
-	client := &http.Client{}
 	req, err := http.NewRequest("POST", run.CancelURL, nil)
 	if err != nil {
 		return err
 	}
 
-	req.Header.Add("Authorization", "token "+api.Token)
-	res, err := client.Do(req)
+	res, err := api.do("cancel_run", req)
 	if err != nil {
 		return err
 	}
 
-	if res.StatusCode != http.StatusAccepted {
-		body, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			return err
-		}
-
-		return fmt.Errorf("Bad status code: %d \nBody: %s", res.StatusCode, body)
-	}
+	_, err = readBody(res)
+	return err
+}
 
-	return nil
+// GroupKey groups the runs by branch
+func (api *GithubAPI) GroupKey(run WorkflowRun) string {
+	return branchKey(run)
 }
 
 // ListWorkflows returns list of workflows
 func (api *GithubAPI) ListWorkflows() ([]WorkflowRun, error) {
-	client := &http.Client{}
+	return api.listRuns(fmt.Sprintf(listRunsEndpointFormat, api.Organization, api.Repository))
+}
+
+// ListBranchWorkflows returns list of workflows of a branch
+func (api *GithubAPI) ListBranchWorkflows(branch string) ([]WorkflowRun, error) {
 	endpoint := fmt.Sprintf(listRunsEndpointFormat, api.Organization, api.Repository)
+	return api.listRuns(endpoint + "?branch=" + url.QueryEscape(branch))
+}
 
+func (api *GithubAPI) listRuns(endpoint string) ([]WorkflowRun, error) {
 	req, err := http.NewRequest("GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("Authorization", "token "+api.Token)
-	res, err := client.Do(req)
+	var cached ETagEntry
+	var isCached bool
+	if api.ETags != nil {
+		cached, isCached = api.ETags.Store.Get(endpoint)
+		if isCached {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+	}
+	res, err := api.do("list_runs", req)
 	if err != nil {
 		return nil, err
 	}
 
-	body, err := ioutil.ReadAll(res.Body)
+	var body []byte
+	if isCached && res.StatusCode == http.StatusNotModified {
+		res.Body.Close()
+		api.countETag(MetricETagHits)
+		body = cached.Body
+	} else {
+		body, err = readBody(res)
+		if err != nil {
+			return nil, err
+		}
+		if api.ETags != nil {
+			api.countETag(MetricETagMisses)
+			if etag := res.Header.Get("ETag"); etag != "" && res.StatusCode == http.StatusOK {
+				api.ETags.Store.Set(endpoint, ETagEntry{ETag: etag, Body: body})
+			}
+		}
+	}
+
+	workflowRunRes, err := parseWorkflowsFrom(body)
 	if err != nil {
 		return nil, err
 	}
 
-	workflowRunRes, err := parseWorkflowsFrom([]byte(body))
+	return workflowRunRes.WorkflowRuns, nil
+}
+
+// countETag counts a hit or miss of the ETag cache in its stats and the metrics
+func (api *GithubAPI) countETag(name string) {
+	if name == MetricETagHits {
+		api.ETags.hit()
+	} else {
+		api.ETags.miss()
+	}
+	if api.Metrics != nil {
+		api.Metrics.Put(name, 1, UnitCount, Dimensions{"Repository": api.Organization + "/" + api.Repository})
+	}
+}
+
+func (api *GithubAPI) getJSON(name, endpoint string, out interface{}) error {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	res, err := api.do(name, req)
+	if err != nil {
+		return err
+	}
+
+	body, err := readBody(res)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, out)
+}
+
+// ETagStats returns the hits and misses of the ETag cache
+func (api *GithubAPI) ETagStats() ETagStats {
+	if api.ETags == nil {
+		return ETagStats{}
+	}
+
+	return api.ETags.Stats()
+}
+
+// ListPullRequestLabels returns the label names of a pull request
+func (api *GithubAPI) ListPullRequestLabels(number int64) ([]string, error) {
+	var labels []Label
+	err := api.getJSON("list_labels", fmt.Sprintf(listLabelsEndpointFormat, api.Organization, api.Repository, number), &labels)
 	if err != nil {
 		return nil, err
 	}
 
-	return workflowRunRes.WorkflowRuns, nil
+	names := make([]string, 0, len(labels))
+	for _, label := range labels {
+		names = append(names, label.Name)
+	}
+
+	return names, nil
+}
+
+// IsPullRequestMerged reports whether the pull request was merged
+func (api *GithubAPI) IsPullRequestMerged(number int64) (bool, error) {
+	res := pullRequestAPIResponse{}
+	err := api.getJSON("get_pull", fmt.Sprintf(pullRequestEndpointFormat, api.Organization, api.Repository, number), &res)
+	return res.Merged, err
+}
+
+// ListOpenPullRequests returns the numbers of the open pull requests from the branch of the head repository,
+// the branch is looked up in the api's repository when the head repository is empty
+func (api *GithubAPI) ListOpenPullRequests(headRepository, branch string) ([]int64, error) {
+	owner := api.Organization
+	if headRepository != "" {
+		owner = strings.SplitN(headRepository, "/", 2)[0]
+	}
+	var pullRequests []PullRequest
+	endpoint := fmt.Sprintf(listOpenPullRequestsEndpointFormat, api.Organization, api.Repository, url.QueryEscape(owner+":"+branch))
+	err := api.getJSON("list_pulls", endpoint, &pullRequests)
+	if err != nil {
+		return nil, err
+	}
+
+	numbers := make([]int64, len(pullRequests))
+	for i, pullRequest := range pullRequests {
+		numbers[i] = pullRequest.Number
+	}
+	return numbers, nil
+}
+
+// GetBranchHead returns the sha of the commit the branch points to
+func (api *GithubAPI) GetBranchHead(branch string) (string, error) {
+	res := branchAPIResponse{}
+	err := api.getJSON("get_branch", fmt.Sprintf(branchEndpointFormat, api.Organization, api.Repository, url.PathEscape(branch)), &res)
+	return res.Commit.SHA, err
+}
+
+// CompareCommits returns the status of head relative to base: ahead, behind, identical or diverged
+func (api *GithubAPI) CompareCommits(base, head string) (string, error) {
+	res := compareAPIResponse{}
+	err := api.getJSON("compare_commits", fmt.Sprintf(compareEndpointFormat, api.Organization, api.Repository, base, head), &res)
+	return res.Status, err
+}
+
+// ListJobs returns the jobs of the latest attempt of a run, following the pages of large matrices
+func (api *GithubAPI) ListJobs(run WorkflowRun) ([]Job, error) {
+	var jobs []Job
+	for page := 1; ; page++ {
+		res := JobAPIResponse{}
+		err := api.getJSON("list_jobs", fmt.Sprintf(listJobsEndpointFormat, api.Organization, api.Repository, run.ID, page), &res)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, res.Jobs...)
+		if len(res.Jobs) == 0 || int64(len(jobs)) >= res.TotalCount {
+			return jobs, nil
+		}
+	}
 }
 
 func parseWorkflowsFrom(body []byte) (WorkflowRunAPIResponse, error) {
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -11,11 +13,15 @@ import (
 	"gopkg.in/h2non/gock.v1"
 )
 
+// gockClient sends through http.DefaultTransport, the only transport gock intercepts
+var gockClient = &http.Client{}
+
 func TestListWorkflows(t *testing.T) {
 	githubAPI := GithubAPI{
 		Organization: "org",
 		Repository:   "repo",
 		Token:        "dummytoken",
+		Client:       gockClient,
 	}
 
 	t.Run("List workflows return error on server error", func(t *testing.T) {
@@ -75,7 +81,7 @@ func TestListWorkflows(t *testing.T) {
 		}
 
 		for i, run := range runs {
-			if run != expectedRuns[i] {
+			if !reflect.DeepEqual(run, expectedRuns[i]) {
 				t.Errorf("Expected run: %d, Actual run: %d", run.ID, expectedRuns[i].ID)
 			}
 		}
@@ -91,6 +97,7 @@ func TestCancelRun(t *testing.T) {
 		Organization: "org",
 		Repository:   "repo",
 		Token:        "dummytoken",
+		Client:       gockClient,
 	}
 
 	t.Run("Cancel Run error", func(t *testing.T) {
@@ -159,3 +166,308 @@ func TestCancelRun(t *testing.T) {
 		}
 	})
 }
+
+func TestMakeGithubAPIFor(t *testing.T) {
+	t.Run("Parses org and repo", func(t *testing.T) {
+		api, err := MakeGithubAPIFor("org/repo")
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		if api.Organization != "org" || api.Repository != "repo" {
+			t.Errorf("Bad api: %s/%s", api.Organization, api.Repository)
+		}
+	})
+
+	t.Run("Bad format", func(t *testing.T) {
+		_, err := MakeGithubAPIFor("repo")
+		if err == nil || err.Error() != "Bad repository format: repo" {
+			t.Errorf("Bad error: %v", err)
+		}
+	})
+}
+
+func TestListPullRequestLabels(t *testing.T) {
+	githubAPI := GithubAPI{
+		Organization: "org",
+		Repository:   "repo",
+		Token:        "dummytoken",
+		Client:       gockClient,
+	}
+
+	t.Run("Lists label names", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://api.github.com").
+			Get("/repos/org/repo/issues/12/labels").
+			MatchHeader("Authorization", "token dummytoken").
+			Reply(http.StatusOK).
+			JSON([]Label{Label{Name: "bug"}, Label{Name: "keep-all-runs"}})
+
+		labels, err := githubAPI.ListPullRequestLabels(12)
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		if !reflect.DeepEqual(labels, []string{"bug", "keep-all-runs"}) {
+			t.Errorf("Bad labels: %v", labels)
+		}
+		if !gock.IsDone() {
+			t.Errorf("Endpoinds was not called")
+		}
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://api.github.com").
+			Get("/repos/org/repo/issues/12/labels").
+			Reply(http.StatusNotFound)
+
+		_, err := githubAPI.ListPullRequestLabels(12)
+		if err == nil || !strings.Contains(err.Error(), "Bad status code: 404") {
+			t.Errorf("Bad error: %v", err)
+		}
+	})
+}
+
+func TestAncestry(t *testing.T) {
+	githubAPI := GithubAPI{
+		Organization: "org",
+		Repository:   "repo",
+		Token:        "dummytoken",
+		Client:       gockClient,
+	}
+
+	t.Run("Branch head", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://api.github.com").
+			Get("/repos/org/repo/branches/feature").
+			MatchHeader("Authorization", "token dummytoken").
+			Reply(http.StatusOK).
+			JSON(`{"name":"feature","commit":{"sha":"abc"}}`)
+
+		head, err := githubAPI.GetBranchHead("feature")
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		if head != "abc" {
+			t.Errorf("Bad head: %s", head)
+		}
+	})
+
+	t.Run("Escapes the branch", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://api.github.com").
+			Get("/repos/org/repo/branches/").
+			AddMatcher(func(req *http.Request, _ *gock.Request) (bool, error) {
+				return req.URL.EscapedPath() == "/repos/org/repo/branches/feature%2Fx", nil
+			}).
+			Reply(http.StatusOK).
+			JSON(`{"name":"feature/x","commit":{"sha":"abc"}}`)
+
+		head, err := githubAPI.GetBranchHead("feature/x")
+		if err != nil || head != "abc" {
+			t.Errorf("Bad head: %s %v", head, err)
+		}
+	})
+
+	t.Run("Compare commits", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://api.github.com").
+			Get("/repos/org/repo/compare/old...new").
+			MatchHeader("Authorization", "token dummytoken").
+			Reply(http.StatusOK).
+			JSON(`{"status":"ahead","ahead_by":2,"behind_by":0}`)
+
+		status, err := githubAPI.CompareCommits("old", "new")
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		if status != "ahead" {
+			t.Errorf("Bad status: %s", status)
+		}
+		if !gock.IsDone() {
+			t.Errorf("Endpoinds was not called")
+		}
+	})
+}
+
+func TestListBranchWorkflows(t *testing.T) {
+	t.Run("Filters on the server", func(t *testing.T) {
+		defer gock.Off()
+		githubAPI := &GithubAPI{Organization: "org", Repository: "repo", Token: "dummytoken", Client: gockClient}
+		gock.New("https://api.github.com").
+			Get("/repos/org/repo/actions/runs").
+			MatchParam("branch", "^feature/a b$").
+			MatchHeader("Authorization", "token dummytoken").
+			Reply(200).
+			JSON(`{"total_count":1,"workflow_runs":[{"id":1,"head_branch":"feature/a b"}]}`)
+
+		runs, err := ListBranchWorkflows(githubAPI, "feature/a b")
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		if len(runs) != 1 || runs[0].ID != 1 {
+			t.Errorf("Bad runs: %v", runs)
+		}
+		if !gock.IsDone() {
+			t.Errorf("Endpoinds was not called")
+		}
+	})
+
+	t.Run("Filters locally", func(t *testing.T) {
+		api := &mockLabelAPI{runs: []WorkflowRun{
+			WorkflowRun{ID: 1, HeadBranch: "master"},
+			WorkflowRun{ID: 2, HeadBranch: "feature"},
+		}}
+
+		runs, err := ListBranchWorkflows(api, "feature")
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		if len(runs) != 1 || runs[0].ID != 2 {
+			t.Errorf("Bad runs: %v", runs)
+		}
+	})
+}
+
+func TestListJobs(t *testing.T) {
+	defer gock.Off()
+	githubAPI := GithubAPI{Organization: "org", Repository: "repo", Token: "dummytoken", Client: gockClient}
+	gock.New("https://api.github.com").
+		Get("/repos/org/repo/actions/runs/5/jobs").
+		MatchParam("per_page", "100").
+		MatchParam("page", "1").
+		MatchHeader("Authorization", "token dummytoken").
+		Reply(http.StatusOK).
+		JSON(`{"total_count":2,"jobs":[{"id":8,"run_id":5,"name":"build","status":"completed"}]}`)
+	gock.New("https://api.github.com").
+		Get("/repos/org/repo/actions/runs/5/jobs").
+		MatchParam("per_page", "100").
+		MatchParam("page", "2").
+		MatchHeader("Authorization", "token dummytoken").
+		Reply(http.StatusOK).
+		JSON(`{"total_count":2,"jobs":[{"id":9,"run_id":5,"name":"report","status":"queued"}]}`)
+
+	jobs, err := githubAPI.ListJobs(WorkflowRun{ID: 5})
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if !reflect.DeepEqual(jobs, []Job{Job{ID: 8, RunID: 5, Name: "build", Status: "completed"}, Job{ID: 9, RunID: 5, Name: "report", Status: "queued"}}) {
+		t.Errorf("Bad jobs: %v", jobs)
+	}
+	if !gock.IsDone() {
+		t.Errorf("Endpoinds was not called")
+	}
+}
+
+func TestIsPullRequestMerged(t *testing.T) {
+	defer gock.Off()
+	githubAPI := GithubAPI{Organization: "org", Repository: "repo", Token: "dummytoken", Client: gockClient}
+	gock.New("https://api.github.com").
+		Get("/repos/org/repo/pulls/7").
+		MatchHeader("Authorization", "token dummytoken").
+		Reply(http.StatusOK).
+		JSON(`{"number":7,"state":"closed","merged":true}`)
+
+	merged, err := githubAPI.IsPullRequestMerged(7)
+	if err != nil || !merged {
+		t.Errorf("Bad merged: %v %v", merged, err)
+	}
+	if !gock.IsDone() {
+		t.Errorf("Endpoinds was not called")
+	}
+}
+
+func TestAPIFromEnv(t *testing.T) {
+	defer os.Unsetenv("GITHUB_API")
+	defer os.Unsetenv("GRAPHQL_COMMITS")
+	defer os.Unsetenv("GITEA_URL")
+	defer os.Unsetenv("SAME_ACTOR_ONLY")
+	rest := &GithubAPI{}
+
+	if api, _ := APIFromEnv(rest); api != rest {
+		t.Errorf("REST api should be the default")
+	}
+
+	os.Setenv("GITHUB_API", "graphql")
+	os.Setenv("GRAPHQL_COMMITS", "10")
+	graphQL, _ := APIFromEnv(rest)
+	api, ok := graphQL.(*GraphQLAPI)
+	if !ok || api.Commits != 10 || api.Refs != 50 {
+		t.Errorf("Bad api: %+v", api)
+	}
+
+	os.Setenv("SAME_ACTOR_ONLY", "true")
+	if _, err := APIFromEnv(rest); err == nil || err.Error() != "Bad SAME_ACTOR_ONLY: GITHUB_API=graphql doesn't expose the triggering actor of a run" {
+		t.Errorf("Bad error: %v", err)
+	}
+
+	os.Setenv("GITEA_URL", "https://codeberg.org/")
+	giteaAPI, _ := APIFromEnv(&GithubAPI{Organization: "org", Repository: "repo"})
+	gitea, ok := giteaAPI.(*GiteaAPI)
+	if !ok || gitea.BaseURL != "https://codeberg.org" || gitea.Repository != "repo" {
+		t.Errorf("Bad api: %+v", gitea)
+	}
+}
+
+func TestProviderFromEnv(t *testing.T) {
+	defer os.Unsetenv("GITLAB_URL")
+	defer os.Unsetenv("GITLAB_TOKEN")
+	defer os.Unsetenv("GITLAB_PROJECT")
+	defer os.Unsetenv("GITHUB_TOKEN")
+	os.Setenv("GITHUB_TOKEN", "githubtoken")
+
+	githubAPI, err := ProviderFromEnv("org/repo", nil, nil)
+	github, ok := githubAPI.(*GithubAPI)
+	if err != nil || !ok || github.Organization != "org" || github.Token != "githubtoken" {
+		t.Errorf("Bad api: %+v %v", githubAPI, err)
+	}
+	if ProviderNameFromEnv() != ProviderGitHub {
+		t.Errorf("Bad provider: %s", ProviderNameFromEnv())
+	}
+
+	os.Setenv("GITLAB_URL", "https://gitlab.com")
+	os.Setenv("GITLAB_PROJECT", "group/sub/repo")
+	if _, err := ProviderFromEnv(RepositoryFromEnv(), nil, nil); err == nil || err.Error() != "Missing GITLAB_TOKEN" {
+		t.Errorf("Bad error: %v", err)
+	}
+
+	os.Setenv("GITLAB_TOKEN", "dummytoken")
+	gitlabAPI, _ := ProviderFromEnv(RepositoryFromEnv(), nil, nil)
+	gitlab, ok := gitlabAPI.(*GitLabAPI)
+	if !ok || gitlab.Project != "group/sub/repo" || gitlab.Token != "dummytoken" {
+		t.Errorf("Bad api: %+v", gitlab)
+	}
+	if ProviderNameFromEnv() != ProviderGitLab {
+		t.Errorf("Bad provider: %s", ProviderNameFromEnv())
+	}
+}
+
+func TestListOpenPullRequests(t *testing.T) {
+	githubAPI := GithubAPI{
+		Organization: "org",
+		Repository:   "repo",
+		Token:        "dummytoken",
+		Client:       gockClient,
+	}
+
+	t.Run("Lists the pull requests of the fork's branch", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://api.github.com").
+			Get("/repos/org/repo/pulls").
+			MatchParam("state", "open").
+			MatchParam("head", "fork:feature").
+			Reply(http.StatusOK).
+			JSON([]map[string]interface{}{{"number": 7}, {"number": 9}})
+
+		numbers, err := githubAPI.ListOpenPullRequests("fork/repo", "feature")
+
+		if err != nil {
+			t.Errorf("Error: %s", err.Error())
+		}
+		if len(numbers) != 2 || numbers[0] != 7 || numbers[1] != 9 {
+			t.Errorf("Bad pull requests: %v", numbers)
+		}
+		if !gock.IsDone() {
+			t.Errorf("Endpoinds was not called")
+		}
+	})
+}
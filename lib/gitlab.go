@@ -0,0 +1,154 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const gitlabListPipelinesEndpointFormat = "%s/api/v4/projects/%s/pipelines"
+const gitlabPageSize = 100
+const gitlabCancelPipelineEndpointFormat = "%s/api/v4/projects/%s/pipelines/%d/cancel"
+const gitlabMergeRequestRefPrefix = "refs/merge-requests/"
+
+// gitlabStatuses maps the pipeline statuses to the run statuses of the policy
+var gitlabStatuses = map[string]string{
+	"created":              "queued",
+	"waiting_for_resource": "queued",
+	"preparing":            "queued",
+	"pending":              "pending",
+	"scheduled":            "pending",
+	"running":              "in_progress",
+	"manual":               "waiting",
+}
+
+// gitlabPipeline is a pipeline of the GitLab api
+type gitlabPipeline struct {
+	ID        int64     `json:"id"`
+	IID       int64     `json:"iid"`
+	SHA       string    `json:"sha"`
+	Ref       string    `json:"ref"`
+	Status    string    `json:"status"`
+	Source    string    `json:"source"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GitLabAPI lists and cancels the pipelines of a GitLab project
+type GitLabAPI struct {
+	BaseURL string
+	// Project is the path of the project like group/subgroup/name
+	Project string
+	Token   string
+	Client  *http.Client
+}
+
+// MakeGitLabAPI creates the api for the instance at baseURL, like https://gitlab.com
+func MakeGitLabAPI(baseURL, project, token string) *GitLabAPI {
+	return &GitLabAPI{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		Project: project,
+		Token:   token,
+		Client:  defaultHTTPClient,
+	}
+}
+
+func (api *GitLabAPI) request(method, endpoint string) ([]byte, error) {
+	return sendRequest(api.Client, method, endpoint, http.Header{"Private-Token": []string{api.Token}})
+}
+
+// ListWorkflows returns the active pipelines as runs, every page of every active status is listed
+// so old pipelines aren't hidden behind the finished ones
+func (api *GitLabAPI) ListWorkflows() ([]WorkflowRun, error) {
+	statuses := make([]string, 0, len(gitlabStatuses))
+	for status := range gitlabStatuses {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+
+	var runs []WorkflowRun
+	seen := make(map[int64]bool)
+	for _, status := range statuses {
+		pipelines, err := api.listPipelines(status)
+		if err != nil {
+			return nil, err
+		}
+		for _, pipeline := range pipelines {
+			// A pipeline changing its status between two listings is listed twice
+			if !seen[pipeline.ID] {
+				seen[pipeline.ID] = true
+				runs = append(runs, api.runOf(pipeline))
+			}
+		}
+	}
+
+	return runs, nil
+}
+
+func (api *GitLabAPI) listPipelines(status string) ([]gitlabPipeline, error) {
+	endpoint := fmt.Sprintf(gitlabListPipelinesEndpointFormat, api.BaseURL, url.PathEscape(api.Project))
+	var pipelines []gitlabPipeline
+	for page := 1; ; page++ {
+		query := url.Values{
+			"status":   []string{status},
+			"per_page": []string{strconv.Itoa(gitlabPageSize)},
+			"page":     []string{strconv.Itoa(page)},
+		}
+		body, err := api.request("GET", endpoint+"?"+query.Encode())
+		if err != nil {
+			return nil, err
+		}
+
+		var pagePipelines []gitlabPipeline
+		err = json.Unmarshal(body, &pagePipelines)
+		if err != nil {
+			return nil, err
+		}
+		pipelines = append(pipelines, pagePipelines...)
+		if len(pagePipelines) < gitlabPageSize {
+			return pipelines, nil
+		}
+	}
+}
+
+func (api *GitLabAPI) runOf(pipeline gitlabPipeline) WorkflowRun {
+	status, ok := gitlabStatuses[pipeline.Status]
+	if !ok {
+		status = "completed"
+	}
+
+	return WorkflowRun{
+		ID:         pipeline.ID,
+		RunNumber:  pipeline.IID,
+		Event:      pipeline.Source,
+		CreatedAt:  pipeline.CreatedAt,
+		HeadBranch: pipeline.Ref,
+		HeadSHA:    pipeline.SHA,
+		Status:     status,
+		CancelURL:  fmt.Sprintf(gitlabCancelPipelineEndpointFormat, api.BaseURL, url.PathEscape(api.Project), pipeline.ID),
+	}
+}
+
+// CancelRun cancels a pipeline
+func (api *GitLabAPI) CancelRun(run WorkflowRun) error {
+	_, err := api.request("POST", run.CancelURL)
+	return err
+}
+
+// GroupKey groups the pipelines by ref, the head and merged results pipelines of a merge request
+// (refs/merge-requests/<iid>/head and .../merge) form one group
+func (api *GitLabAPI) GroupKey(run WorkflowRun) string {
+	if !strings.HasPrefix(run.HeadBranch, gitlabMergeRequestRefPrefix) {
+		return run.HeadBranch
+	}
+
+	iid := strings.TrimPrefix(run.HeadBranch, gitlabMergeRequestRefPrefix)
+	if slash := strings.Index(iid, "/"); slash >= 0 {
+		iid = iid[:slash]
+	}
+	return gitlabMergeRequestRefPrefix + iid
+}
@@ -0,0 +1,93 @@
+package lib
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGitLabAPI(t *testing.T) {
+	pending := `[
+		{"id":14,"iid":4,"sha":"d","ref":"refs/merge-requests/7/merge","status":"pending","source":"merge_request_event","created_at":"2020-02-29T00:00:03Z"}
+	]`
+	running := `[
+		{"id":13,"iid":3,"sha":"c","ref":"refs/merge-requests/7/head","status":"running","source":"merge_request_event","created_at":"2020-02-29T00:00:02Z"},
+		{"id":12,"iid":2,"sha":"b","ref":"main","status":"running","source":"push","created_at":"2020-02-29T00:00:01Z"}
+	]`
+	gitlab := &fakeForge{header: "Private-Token", token: "dummytoken", routes: map[string]string{
+		"GET /api/v4/projects/group%2Fsub%2Frepo/pipelines":                                    `[]`,
+		"GET /api/v4/projects/group%2Fsub%2Frepo/pipelines?page=1&per_page=100&status=pending": pending,
+		"GET /api/v4/projects/group%2Fsub%2Frepo/pipelines?page=1&per_page=100&status=running": running,
+		"POST /api/v4/projects/group%2Fsub%2Frepo/pipelines/13/cancel":                         `{}`,
+	}}
+	server := httptest.NewServer(gitlab)
+	defer server.Close()
+	api := MakeGitLabAPI(server.URL, "group/sub/repo", "dummytoken")
+
+	t.Run("List pipelines", func(t *testing.T) {
+		runs, err := api.ListWorkflows()
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+
+		if len(runs) != 3 {
+			t.Fatalf("Bad runs: %v", runs)
+		}
+		if runs[0].Status != "pending" || runs[1].Status != "in_progress" || runs[2].Status != "in_progress" {
+			t.Errorf("Bad statuses: %v", runs)
+		}
+		if runs[1].HeadBranch != "refs/merge-requests/7/head" || runs[1].HeadSHA != "c" || runs[1].RunNumber != 3 {
+			t.Errorf("Bad run: %+v", runs[1])
+		}
+		if runs[1].CancelURL != server.URL+"/api/v4/projects/group%2Fsub%2Frepo/pipelines/13/cancel" {
+			t.Errorf("Bad cancel url: %s", runs[1].CancelURL)
+		}
+	})
+
+	t.Run("Follows the pages", func(t *testing.T) {
+		page := make([]string, 0, gitlabPageSize)
+		for i := 0; i < gitlabPageSize; i++ {
+			page = append(page, fmt.Sprintf(`{"id":%d,"ref":"main","status":"created"}`, 100+i))
+		}
+		gitlab.routes["GET /api/v4/projects/group%2Fsub%2Frepo/pipelines?page=1&per_page=100&status=created"] = "[" + strings.Join(page, ",") + "]"
+		gitlab.routes["GET /api/v4/projects/group%2Fsub%2Frepo/pipelines?page=2&per_page=100&status=created"] = `[{"id":99,"ref":"main","status":"created"}]`
+		defer delete(gitlab.routes, "GET /api/v4/projects/group%2Fsub%2Frepo/pipelines?page=1&per_page=100&status=created")
+		defer delete(gitlab.routes, "GET /api/v4/projects/group%2Fsub%2Frepo/pipelines?page=2&per_page=100&status=created")
+
+		runs, err := api.ListWorkflows()
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+
+		if len(runs) != gitlabPageSize+4 || runs[gitlabPageSize].ID != 99 || runs[gitlabPageSize].Status != "queued" {
+			t.Errorf("Bad runs: %v", runs)
+		}
+	})
+
+	t.Run("Groups merge request pipelines", func(t *testing.T) {
+		keys := []string{
+			api.GroupKey(WorkflowRun{HeadBranch: "refs/merge-requests/7/merge"}),
+			api.GroupKey(WorkflowRun{HeadBranch: "refs/merge-requests/7/head"}),
+			api.GroupKey(WorkflowRun{HeadBranch: "main"}),
+		}
+
+		if keys[0] != "refs/merge-requests/7" || keys[1] != keys[0] || keys[2] != "main" {
+			t.Errorf("Bad group keys: %v", keys)
+		}
+	})
+
+	t.Run("Cancels the superseded merge request pipeline", func(t *testing.T) {
+		gitlab.requests = nil
+		runs, _ := api.ListWorkflows()
+
+		err := MakeDefaultPolicy().AutomaticCancel(api, runs)
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+
+		if len(gitlab.requests) != 8 || gitlab.requests[7] != "POST /api/v4/projects/group%2Fsub%2Frepo/pipelines/13/cancel?" {
+			t.Errorf("Bad requests: %v", gitlab.requests)
+		}
+	})
+}
@@ -0,0 +1,255 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const graphQLEndpoint = "https://api.github.com/graphql"
+const cancelRunEndpointFormat = "https://api.github.com/repos/%s/%s/actions/runs/%d/cancel"
+
+// The check suites of the newest commits of the most recently committed branches, their workflow runs are the runs of the branch
+const listRunsQuery = `query($owner: String!, $name: String!, $refs: Int!, $commits: Int!) {
+  repository(owner: $owner, name: $name) {
+    refs(refPrefix: "refs/heads/", first: $refs, orderBy: {field: TAG_COMMIT_DATE, direction: DESC}) {
+      nodes { target { ...commits } }
+    }
+  }
+}` + commitsFragment
+
+const listBranchRunsQuery = `query($owner: String!, $name: String!, $branch: String!, $commits: Int!) {
+  repository(owner: $owner, name: $name) {
+    ref(qualifiedName: $branch) { target { ...commits } }
+  }
+}` + commitsFragment
+
+const commitsFragment = `
+fragment commits on Commit {
+  history(first: $commits) {
+    nodes {
+      oid
+      message
+      checkSuites(first: 50) {
+        nodes {
+          status
+          branch { name }
+          creator { login }
+          matchingPullRequests(first: 5) { nodes { number headRefName headRefOid } }
+          workflowRun { databaseId runNumber createdAt event workflow { name } file { path } }
+        }
+      }
+    }
+  }
+}`
+
+type graphQLTarget struct {
+	History struct {
+		Nodes []struct {
+			OID         string `json:"oid"`
+			Message     string `json:"message"`
+			CheckSuites struct {
+				Nodes []graphQLCheckSuite `json:"nodes"`
+			} `json:"checkSuites"`
+		} `json:"nodes"`
+	} `json:"history"`
+}
+
+type graphQLCheckSuite struct {
+	Status string `json:"status"`
+	Branch *struct {
+		Name string `json:"name"`
+	} `json:"branch"`
+	Creator *struct {
+		Login string `json:"login"`
+	} `json:"creator"`
+	MatchingPullRequests struct {
+		Nodes []struct {
+			Number      int64  `json:"number"`
+			HeadRefName string `json:"headRefName"`
+			HeadRefOID  string `json:"headRefOid"`
+		} `json:"nodes"`
+	} `json:"matchingPullRequests"`
+	WorkflowRun *struct {
+		DatabaseID int64     `json:"databaseId"`
+		RunNumber  int64     `json:"runNumber"`
+		CreatedAt  time.Time `json:"createdAt"`
+		Event      string    `json:"event"`
+		Workflow   struct {
+			Name string `json:"name"`
+		} `json:"workflow"`
+		File *struct {
+			Path string `json:"path"`
+		} `json:"file"`
+	} `json:"workflowRun"`
+}
+
+type graphQLResponse struct {
+	Data struct {
+		Repository *struct {
+			Refs struct {
+				Nodes []struct {
+					Target graphQLTarget `json:"target"`
+				} `json:"nodes"`
+			} `json:"refs"`
+			Ref *struct {
+				Target graphQLTarget `json:"target"`
+			} `json:"ref"`
+		} `json:"repository"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// GraphQLAPI lists the runs of the newest commits of the most recently committed branches with one GraphQL query.
+// Everything else, like cancelling a run, goes through the REST api.
+// GraphQL doesn't expose the attempt and the triggering actor of a run, the creator of the check suite is the actor.
+type GraphQLAPI struct {
+	*GithubAPI
+	// Refs is the number of most recently committed branches listed
+	Refs int
+	// Commits is the number of newest commits listed per branch
+	Commits int
+}
+
+// MakeGraphQLAPI creates the api on top of the REST api
+func MakeGraphQLAPI(api *GithubAPI) *GraphQLAPI {
+	return &GraphQLAPI{GithubAPI: api, Refs: 50, Commits: 5}
+}
+
+// ListWorkflows returns the runs of the newest commits of the Refs most recently committed branches.
+// Branches further down aren't paged through, and runs of fork pull requests aren't on a branch of the repository.
+func (api *GraphQLAPI) ListWorkflows() ([]WorkflowRun, error) {
+	res, err := api.query(listRunsQuery, map[string]interface{}{
+		"owner":   api.Organization,
+		"name":    api.Repository,
+		"refs":    api.Refs,
+		"commits": api.Commits,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []graphQLTarget
+	for _, ref := range res.Data.Repository.Refs.Nodes {
+		targets = append(targets, ref.Target)
+	}
+	return api.runsOf(targets), nil
+}
+
+// ListBranchWorkflows returns the runs of the newest commits of a branch
+func (api *GraphQLAPI) ListBranchWorkflows(branch string) ([]WorkflowRun, error) {
+	res, err := api.query(listBranchRunsQuery, map[string]interface{}{
+		"owner":   api.Organization,
+		"name":    api.Repository,
+		"branch":  "refs/heads/" + branch,
+		"commits": api.Commits,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Data.Repository.Ref == nil {
+		return nil, nil
+	}
+	return api.runsOf([]graphQLTarget{res.Data.Repository.Ref.Target}), nil
+}
+
+func (api *GraphQLAPI) query(query string, variables map[string]interface{}) (graphQLResponse, error) {
+	res := graphQLResponse{}
+	payload, err := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	if err != nil {
+		return res, err
+	}
+
+	req, err := http.NewRequest("POST", graphQLEndpoint, bytes.NewReader(payload))
+	if err != nil {
+		return res, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	httpRes, err := api.do("graphql", req)
+	if err != nil {
+		return res, err
+	}
+	defer httpRes.Body.Close()
+
+	body, err := ioutil.ReadAll(httpRes.Body)
+	if err != nil {
+		return res, err
+	}
+	if httpRes.StatusCode != http.StatusOK {
+		return res, &StatusError{StatusCode: httpRes.StatusCode, Body: body}
+	}
+
+	err = json.Unmarshal(body, &res)
+	if err != nil {
+		return res, err
+	}
+	if len(res.Errors) > 0 {
+		messages := make([]string, 0, len(res.Errors))
+		for _, graphQLError := range res.Errors {
+			messages = append(messages, graphQLError.Message)
+		}
+		return res, fmt.Errorf("GraphQL error: %s", strings.Join(messages, ", "))
+	}
+	if res.Data.Repository == nil {
+		return res, fmt.Errorf("Repository not found: %s/%s", api.Organization, api.Repository)
+	}
+
+	return res, nil
+}
+
+// runsOf converts the check suites with a workflow run, commits shared by branches are listed once
+func (api *GraphQLAPI) runsOf(targets []graphQLTarget) []WorkflowRun {
+	var runs []WorkflowRun
+	seen := make(map[int64]bool)
+	for _, target := range targets {
+		for _, commit := range target.History.Nodes {
+			for _, suite := range commit.CheckSuites.Nodes {
+				if suite.WorkflowRun == nil || seen[suite.WorkflowRun.DatabaseID] {
+					continue
+				}
+				seen[suite.WorkflowRun.DatabaseID] = true
+				runs = append(runs, api.runOf(commit.OID, commit.Message, suite))
+			}
+		}
+	}
+
+	return runs
+}
+
+func (api *GraphQLAPI) runOf(sha, message string, suite graphQLCheckSuite) WorkflowRun {
+	run := WorkflowRun{
+		ID:         suite.WorkflowRun.DatabaseID,
+		RunNumber:  suite.WorkflowRun.RunNumber,
+		Name:       suite.WorkflowRun.Workflow.Name,
+		Event:      suite.WorkflowRun.Event,
+		CreatedAt:  suite.WorkflowRun.CreatedAt,
+		HeadSHA:    sha,
+		Status:     strings.ToLower(suite.Status),
+		CancelURL:  fmt.Sprintf(cancelRunEndpointFormat, api.Organization, api.Repository, suite.WorkflowRun.DatabaseID),
+		HeadCommit: &HeadCommit{ID: sha, Message: message},
+	}
+	if suite.WorkflowRun.File != nil {
+		run.Path = suite.WorkflowRun.File.Path
+	}
+	if suite.Branch != nil {
+		run.HeadBranch = suite.Branch.Name
+	}
+	if suite.Creator != nil {
+		run.Actor = &Actor{Login: suite.Creator.Login}
+	}
+	for _, pullRequest := range suite.MatchingPullRequests.Nodes {
+		pr := PullRequest{Number: pullRequest.Number}
+		pr.Head.Ref = pullRequest.HeadRefName
+		pr.Head.SHA = pullRequest.HeadRefOID
+		run.PullRequests = append(run.PullRequests, pr)
+	}
+
+	return run
+}
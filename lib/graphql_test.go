@@ -0,0 +1,156 @@
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/h2non/gock.v1"
+)
+
+const graphQLRunsReply = `{"data":{"repository":{"refs":{"nodes":[
+  {"target":{"history":{"nodes":[
+    {"oid":"b","message":"Second","checkSuites":{"nodes":[
+      {"status":"IN_PROGRESS","branch":{"name":"feature"},"creator":{"login":"octocat"},
+       "matchingPullRequests":{"nodes":[{"number":7,"headRefName":"feature","headRefOid":"b"}]},
+       "workflowRun":{"databaseId":2,"runNumber":12,"createdAt":"2020-02-29T00:00:01Z","event":"pull_request","workflow":{"name":"CI"},"file":{"path":".github/workflows/ci.yml"}}},
+      {"status":"QUEUED","branch":{"name":"feature"},"matchingPullRequests":{"nodes":[]},"workflowRun":null}
+    ]}},
+    {"oid":"a","message":"First","checkSuites":{"nodes":[
+      {"status":"QUEUED","branch":{"name":"feature"},"matchingPullRequests":{"nodes":[]},
+       "workflowRun":{"databaseId":1,"runNumber":11,"createdAt":"2020-02-29T00:00:00Z","event":"push","workflow":{"name":"CI"}}}
+    ]}}
+  ]}}},
+  {"target":{"history":{"nodes":[
+    {"oid":"a","message":"First","checkSuites":{"nodes":[
+      {"status":"QUEUED","branch":{"name":"feature"},"matchingPullRequests":{"nodes":[]},
+       "workflowRun":{"databaseId":1,"runNumber":11,"createdAt":"2020-02-29T00:00:00Z","event":"push","workflow":{"name":"CI"}}}
+    ]}}
+  ]}}}
+]}}}}`
+
+func TestGraphQLAPIListWorkflows(t *testing.T) {
+	api := MakeGraphQLAPI(&GithubAPI{Organization: "org", Repository: "repo", Token: "dummytoken", Client: gockClient})
+
+	t.Run("Lists the runs of the newest commits", func(t *testing.T) {
+		defer gock.Off()
+		var variables map[string]interface{}
+		gock.New("https://api.github.com").
+			Post("/graphql").
+			MatchHeader("Authorization", "token dummytoken").
+			AddMatcher(func(req *http.Request, _ *gock.Request) (bool, error) {
+				body, _ := ioutil.ReadAll(req.Body)
+				payload := struct {
+					Variables map[string]interface{} `json:"variables"`
+				}{}
+				err := json.Unmarshal(body, &payload)
+				variables = payload.Variables
+				return err == nil, err
+			}).
+			Reply(http.StatusOK).
+			BodyString(graphQLRunsReply)
+
+		runs, err := api.ListWorkflows()
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+
+		newest := WorkflowRun{
+			ID:         2,
+			RunNumber:  12,
+			Name:       "CI",
+			Path:       ".github/workflows/ci.yml",
+			Event:      "pull_request",
+			CreatedAt:  time.Date(2020, 02, 29, 0, 0, 1, 0, time.UTC),
+			HeadBranch: "feature",
+			HeadSHA:    "b",
+			Status:     "in_progress",
+			CancelURL:  "https://api.github.com/repos/org/repo/actions/runs/2/cancel",
+			HeadCommit: &HeadCommit{ID: "b", Message: "Second"},
+			Actor:      &Actor{Login: "octocat"},
+		}
+		newest.PullRequests = []PullRequest{PullRequest{Number: 7}}
+		newest.PullRequests[0].Head.Ref = "feature"
+		newest.PullRequests[0].Head.SHA = "b"
+		if len(runs) != 2 {
+			t.Fatalf("Bad runs: %v", runs)
+		}
+		if !reflect.DeepEqual(runs[0], newest) {
+			t.Errorf("Bad run: %+v", runs[0])
+		}
+		if runs[1].ID != 1 || runs[1].Status != "queued" || runs[1].HeadSHA != "a" {
+			t.Errorf("Bad run: %+v", runs[1])
+		}
+		if variables["owner"] != "org" || variables["name"] != "repo" || variables["refs"] != float64(50) || variables["commits"] != float64(5) || len(variables) != 4 {
+			t.Errorf("Bad variables: %v", variables)
+		}
+		if !gock.IsDone() {
+			t.Errorf("Endpoinds was not called")
+		}
+	})
+
+	t.Run("Lists the most recently committed branches once", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://api.github.com").
+			Post("/graphql").
+			BodyString(`orderBy: {field: TAG_COMMIT_DATE, direction: DESC}`).
+			Reply(http.StatusOK).
+			BodyString(`{"data":{"repository":{"refs":{"pageInfo":{"hasNextPage":true,"endCursor":"c1"},"nodes":[]}}}}`)
+
+		runs, err := api.ListWorkflows()
+		if err != nil || len(runs) != 0 {
+			t.Errorf("Bad runs: %v %v", runs, err)
+		}
+		if !gock.IsDone() || gock.HasUnmatchedRequest() {
+			t.Errorf("Bad requests")
+		}
+	})
+
+	t.Run("GraphQL errors", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://api.github.com").
+			Post("/graphql").
+			Reply(http.StatusOK).
+			BodyString(`{"data":{"repository":null},"errors":[{"message":"Bad credentials"}]}`)
+
+		_, err := api.ListWorkflows()
+		if err == nil || err.Error() != "GraphQL error: Bad credentials" {
+			t.Errorf("Bad error: %v", err)
+		}
+	})
+
+	t.Run("Branch", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://api.github.com").
+			Post("/graphql").
+			BodyString(`refs/heads/feature`).
+			Reply(http.StatusOK).
+			BodyString(`{"data":{"repository":{"ref":null}}}`)
+
+		runs, err := ListBranchWorkflows(api, "feature")
+		if err != nil || len(runs) != 0 {
+			t.Errorf("Bad runs: %v %v", runs, err)
+		}
+		if !gock.IsDone() {
+			t.Errorf("Endpoinds was not called")
+		}
+	})
+
+	t.Run("Cancels through the REST api", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://api.github.com").
+			Post("/repos/org/repo/actions/runs/2/cancel").
+			Reply(http.StatusAccepted)
+
+		err := api.CancelRun(WorkflowRun{CancelURL: "https://api.github.com/repos/org/repo/actions/runs/2/cancel"})
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		if _, ok := Provider(api).(PullRequestLabeler); !ok {
+			t.Errorf("GraphQL api should look up labels through the REST api")
+		}
+	})
+}
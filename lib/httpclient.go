@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+// DefaultHTTPTimeout bounds a whole request to the api, including reading the body
+const DefaultHTTPTimeout = 10 * time.Second
+
+// MakeHTTPClient creates a client with dial, TLS and overall timeouts which reuses its connections
+func MakeHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   5 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			ForceAttemptHTTP2:     true,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ResponseHeaderTimeout: timeout,
+			ExpectContinueTimeout: time.Second,
+			MaxIdleConns:          20,
+			MaxIdleConnsPerHost:   10,
+			IdleConnTimeout:       90 * time.Second,
+		},
+	}
+}
+
+// defaultHTTPClient is shared by the apis made from the environment and the apis without a client,
+// so they share the connection pool
+var defaultHTTPClient = MakeHTTPClient(DefaultHTTPTimeout)
+
+func clientOrDefault(client *http.Client) *http.Client {
+	if client == nil {
+		return defaultHTTPClient
+	}
+
+	return client
+}
+
+// readBody reads and closes the body, responses without a 2xx status are StatusErrors
+func readBody(res *http.Response) ([]byte, error) {
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, &StatusError{StatusCode: res.StatusCode, Body: body}
+	}
+
+	return body, nil
+}
+
+// sendRequest sends a request without body with the header and returns the body of the response
+func sendRequest(client *http.Client, method, endpoint string, header http.Header) ([]byte, error) {
+	req, err := http.NewRequest(method, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	res, err := clientOrDefault(client).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return readBody(res)
+}
@@ -0,0 +1,145 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// fakeForge answers the requests carrying the token in the header with the bodies of the routes,
+// routes are keyed by method and escaped path like "GET /api/v1/repos/org/repo/actions/runs",
+// a route with the query like "GET /runs?page=2" is preferred
+type fakeForge struct {
+	header   string
+	token    string
+	routes   map[string]string
+	requests []string
+}
+
+func (forge *fakeForge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get(forge.header) != forge.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	route := r.Method + " " + r.URL.EscapedPath()
+	forge.requests = append(forge.requests, route+"?"+r.URL.RawQuery)
+	body, ok := forge.routes[route+"?"+r.URL.RawQuery]
+	if !ok {
+		body, ok = forge.routes[route]
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	fmt.Fprint(w, body)
+}
+
+func TestSendRequest(t *testing.T) {
+	forge := &fakeForge{header: "Private-Token", token: "dummytoken", routes: map[string]string{"GET /runs": `[]`}}
+	server := httptest.NewServer(forge)
+	defer server.Close()
+
+	t.Run("Returns the body", func(t *testing.T) {
+		body, err := sendRequest(nil, "GET", server.URL+"/runs?page=2", http.Header{"Private-Token": []string{"dummytoken"}})
+
+		if err != nil || string(body) != "[]" {
+			t.Errorf("Bad response: %s %v", body, err)
+		}
+		if len(forge.requests) != 1 || forge.requests[0] != "GET /runs?page=2" {
+			t.Errorf("Bad requests: %v", forge.requests)
+		}
+	})
+
+	t.Run("Bad token", func(t *testing.T) {
+		_, err := sendRequest(nil, "GET", server.URL+"/runs", http.Header{"Private-Token": []string{"other"}})
+
+		if err == nil || err.Error() != "Bad status code: 401 \nBody: " {
+			t.Errorf("Bad error: %v", err)
+		}
+	})
+}
+
+func TestGithubAPIClient(t *testing.T) {
+	t.Run("Uses the injected client", func(t *testing.T) {
+		var requests []string
+		githubAPI := GithubAPI{
+			Organization: "org",
+			Repository:   "repo",
+			Token:        "dummytoken",
+			Client: &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				requests = append(requests, req.Method+" "+req.URL.String()+" "+req.Header.Get("Authorization"))
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       ioutil.NopCloser(strings.NewReader(`{"total_count":1,"workflow_runs":[{"id":1}]}`)),
+				}, nil
+			})},
+		}
+
+		runs, err := githubAPI.ListWorkflows()
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		if len(runs) != 1 || runs[0].ID != 1 {
+			t.Errorf("Bad runs: %v", runs)
+		}
+		if len(requests) != 1 || requests[0] != "GET https://api.github.com/repos/org/repo/actions/runs token dummytoken" {
+			t.Errorf("Bad requests: %v", requests)
+		}
+	})
+
+	t.Run("Times out hung requests", func(t *testing.T) {
+		done := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-done
+		}))
+		defer server.Close()
+		defer close(done)
+		githubAPI := GithubAPI{Token: "dummytoken", Client: MakeHTTPClient(50 * time.Millisecond)}
+
+		start := time.Now()
+		err := githubAPI.CancelRun(WorkflowRun{CancelURL: server.URL})
+		if err == nil {
+			t.Errorf("Missing error")
+		}
+		if time.Since(start) > time.Second {
+			t.Errorf("Request was not timed out: %s", time.Since(start))
+		}
+	})
+
+	t.Run("Reuses connections", func(t *testing.T) {
+		connections := 0
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				connections++
+			}
+		}
+		server.Start()
+		defer server.Close()
+		githubAPI := GithubAPI{Token: "dummytoken", Client: MakeHTTPClient(time.Second)}
+
+		for i := 0; i < 3; i++ {
+			err := githubAPI.CancelRun(WorkflowRun{CancelURL: server.URL})
+			if err != nil {
+				t.Errorf(err.Error())
+			}
+		}
+		if connections != 1 {
+			t.Errorf("Expected 1 connection, actual %d", connections)
+		}
+	})
+}
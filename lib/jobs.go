@@ -0,0 +1,62 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Job is a job of a workflow run
+type Job struct {
+	ID         int64  `json:"id"`
+	RunID      int64  `json:"run_id"`
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Conclusion string `json:"conclusion"`
+}
+
+// JobAPIResponse struct
+type JobAPIResponse struct {
+	TotalCount int64 `json:"total_count"`
+	Jobs       []Job `json:"jobs"`
+}
+
+// matchesJob reports whether the job name is one of the names, matrix jobs like "e2e (chrome)" match "e2e"
+func matchesJob(names []string, job Job) bool {
+	for _, name := range names {
+		if job.Name == name || strings.HasPrefix(job.Name, name+" (") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// JobLister is implemented by apis which can list the jobs of a run.
+// GitHub can only cancel whole runs, so the jobs are only used to wait for protected jobs.
+type JobLister interface {
+	ListJobs(run WorkflowRun) ([]Job, error)
+}
+
+// protectedJobsRunning returns why the run has to wait for its protected jobs, or an empty string
+func (policy *Policy) protectedJobsRunning(api Provider, run WorkflowRun) string {
+	if len(policy.ProtectedJobs) == 0 {
+		return ""
+	}
+
+	lister, ok := api.(JobLister)
+	if !ok {
+		return "jobs can't be listed to wait for the protected jobs"
+	}
+	jobs, err := lister.ListJobs(run)
+	if err != nil {
+		return fmt.Sprintf("listing jobs failed: %s", err.Error())
+	}
+
+	for _, job := range jobs {
+		if matchesJob(policy.ProtectedJobs, job) && job.Status != "completed" {
+			return fmt.Sprintf("protected job %s is %s, single jobs can't be cancelled", job.Name, job.Status)
+		}
+	}
+
+	return ""
+}
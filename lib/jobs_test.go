@@ -0,0 +1,78 @@
+package lib
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+type mockJobAPI struct {
+	mockLabelAPI
+	jobs map[int64][]Job
+	err  error
+}
+
+func (api *mockJobAPI) ListJobs(run WorkflowRun) ([]Job, error) {
+	return api.jobs[run.ID], api.err
+}
+
+func TestDecideProtectedJobs(t *testing.T) {
+	runs := func() []WorkflowRun {
+		return []WorkflowRun{
+			WorkflowRun{ID: 1, HeadBranch: "master", HeadSHA: "a", Status: "in_progress", CreatedAt: time.Unix(1, 0)},
+			WorkflowRun{ID: 2, HeadBranch: "master", HeadSHA: "b", Status: "in_progress", CreatedAt: time.Unix(2, 0)},
+		}
+	}
+	policy := MakeDefaultPolicy()
+	policy.ProtectedJobs = []string{"report"}
+
+	t.Run("Waits for protected jobs", func(t *testing.T) {
+		api := &mockJobAPI{jobs: map[int64][]Job{1: []Job{
+			Job{Name: "e2e (chrome)", Status: "in_progress"},
+			Job{Name: "report", Status: "queued"},
+		}}}
+
+		decisions := policy.Decide(api, runs())
+
+		if decisions[1].Cancel || decisions[1].Reason != "protected job report is queued, single jobs can't be cancelled" {
+			t.Errorf("Bad decision: %+v", decisions[1])
+		}
+	})
+
+	t.Run("Cancels once protected jobs completed", func(t *testing.T) {
+		api := &mockJobAPI{jobs: map[int64][]Job{1: []Job{
+			Job{Name: "e2e (chrome)", Status: "in_progress"},
+			Job{Name: "report", Status: "completed"},
+		}}}
+
+		decisions := policy.Decide(api, runs())
+
+		if !decisions[1].Cancel {
+			t.Errorf("Bad decision: %+v", decisions[1])
+		}
+	})
+
+	t.Run("Matrix jobs", func(t *testing.T) {
+		api := &mockJobAPI{jobs: map[int64][]Job{1: []Job{Job{Name: "report (linux)", Status: "in_progress"}}}}
+
+		decisions := policy.Decide(api, runs())
+
+		if decisions[1].Cancel {
+			t.Errorf("Bad decision: %+v", decisions[1])
+		}
+	})
+
+	t.Run("Keeps runs when jobs can't be listed", func(t *testing.T) {
+		decisions := policy.Decide(&mockLabelAPI{}, runs())
+
+		if decisions[1].Cancel || decisions[1].Reason != "jobs can't be listed to wait for the protected jobs" {
+			t.Errorf("Bad decision: %+v", decisions[1])
+		}
+
+		decisions = policy.Decide(&mockJobAPI{err: fmt.Errorf("Server error")}, runs())
+
+		if decisions[1].Cancel || decisions[1].Reason != "listing jobs failed: Server error" {
+			t.Errorf("Bad decision: %+v", decisions[1])
+		}
+	})
+}
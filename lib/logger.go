@@ -0,0 +1,197 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const redacted = "REDACTED"
+
+// Level of a log line
+type Level int
+
+// Log levels
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (level Level) String() string {
+	if level < LevelDebug || level > LevelError {
+		return fmt.Sprintf("level(%d)", int(level))
+	}
+
+	return levelNames[level]
+}
+
+// ParseLevel parses debug, info, warn or error
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+
+	return LevelInfo, fmt.Errorf("Bad log level: %s", name)
+}
+
+// Fields are the structured fields of a log line
+type Fields map[string]interface{}
+
+var sensitiveFieldParts = []string{"token", "secret", "authorization", "signature", "password"}
+
+// Logger writes leveled JSON lines, the secrets and fields named like secrets are never written.
+// The zero value writes every level to stderr.
+type Logger struct {
+	Writer  io.Writer
+	Level   Level
+	Secrets []string
+	Now     func() time.Time
+
+	fields Fields
+	// mu is shared with the loggers made by With, loggers without one use zeroLoggerMu
+	mu *sync.Mutex
+}
+
+var zeroLoggerMu sync.Mutex
+
+// MakeLogger creates a logger writing lines of at least level, the secrets are scrubbed from every line
+func MakeLogger(writer io.Writer, level Level, secrets ...string) *Logger {
+	return &Logger{Writer: writer, Level: level, Secrets: secrets, Now: time.Now, mu: &sync.Mutex{}}
+}
+
+// MakeLoggerFromEnv creates a logger of LOG_LEVEL (default info) which scrubs the tokens and the webhook secret
+func MakeLoggerFromEnv(writer io.Writer) (*Logger, error) {
+	level := LevelInfo
+	if name := os.Getenv("LOG_LEVEL"); name != "" {
+		var err error
+		level, err = ParseLevel(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return MakeLogger(writer, level, os.Getenv("GITHUB_TOKEN"), os.Getenv("GITLAB_TOKEN"), os.Getenv("WEBHOOK_SECRET")), nil
+}
+
+var defaultLogger = MakeLogger(os.Stderr, LevelInfo)
+
+// DefaultLogger returns the logger used when none is configured
+func DefaultLogger() *Logger {
+	return defaultLogger
+}
+
+// SetDefaultLogger replaces the logger used when none is configured
+func SetDefaultLogger(logger *Logger) {
+	defaultLogger = logger
+}
+
+// With returns a logger adding the fields to every line
+func (logger *Logger) With(fields Fields) *Logger {
+	child := *logger
+	child.fields = make(Fields, len(logger.fields)+len(fields))
+	for key, value := range logger.fields {
+		child.fields[key] = value
+	}
+	for key, value := range fields {
+		child.fields[key] = value
+	}
+
+	return &child
+}
+
+// Debug writes a debug line
+func (logger *Logger) Debug(msg string, fields Fields) {
+	logger.write(LevelDebug, msg, fields)
+}
+
+// Info writes an info line
+func (logger *Logger) Info(msg string, fields Fields) {
+	logger.write(LevelInfo, msg, fields)
+}
+
+// Warn writes a warning line
+func (logger *Logger) Warn(msg string, fields Fields) {
+	logger.write(LevelWarn, msg, fields)
+}
+
+// Error writes an error line
+func (logger *Logger) Error(msg string, fields Fields) {
+	logger.write(LevelError, msg, fields)
+}
+
+func (logger *Logger) scrub(value string) string {
+	for _, secret := range logger.Secrets {
+		if secret != "" {
+			value = strings.Replace(value, secret, redacted, -1)
+		}
+	}
+
+	return value
+}
+
+func (logger *Logger) value(key string, value interface{}) interface{} {
+	lower := strings.ToLower(key)
+	for _, part := range sensitiveFieldParts {
+		if strings.Contains(lower, part) {
+			return redacted
+		}
+	}
+
+	switch typed := value.(type) {
+	case string:
+		return logger.scrub(typed)
+	case error:
+		return logger.scrub(typed.Error())
+	case fmt.Stringer:
+		return logger.scrub(typed.String())
+	}
+	return value
+}
+
+func (logger *Logger) write(level Level, msg string, fields Fields) {
+	if level < logger.Level {
+		return
+	}
+
+	line := make(map[string]interface{}, len(logger.fields)+len(fields)+3)
+	for key, value := range logger.fields {
+		line[key] = logger.value(key, value)
+	}
+	for key, value := range fields {
+		line[key] = logger.value(key, value)
+	}
+	now := logger.Now
+	if now == nil {
+		now = time.Now
+	}
+	line["time"] = now().UTC().Format(time.RFC3339Nano)
+	line["level"] = level.String()
+	line["msg"] = logger.scrub(msg)
+
+	encoded, err := json.Marshal(line)
+	if err != nil {
+		encoded, _ = json.Marshal(map[string]string{"level": "error", "msg": "Encoding log line failed: " + err.Error()})
+	}
+
+	mu := logger.mu
+	if mu == nil {
+		mu = &zeroLoggerMu
+	}
+	writer := logger.Writer
+	if writer == nil {
+		writer = os.Stderr
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	writer.Write(append(encoded, '\n'))
+}
@@ -0,0 +1,179 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockCancelAPI struct {
+	failing map[int64]bool
+}
+
+func (api *mockCancelAPI) ListWorkflows() ([]WorkflowRun, error) {
+	return nil, nil
+}
+
+func (api *mockCancelAPI) CancelRun(run WorkflowRun) error {
+	if api.failing[run.ID] {
+		return fmt.Errorf("Server error")
+	}
+	return nil
+}
+
+func (api *mockCancelAPI) GroupKey(run WorkflowRun) string {
+	return run.HeadBranch
+}
+
+func makeTestLogger(level Level, secrets ...string) (*Logger, *bytes.Buffer) {
+	output := &bytes.Buffer{}
+	logger := MakeLogger(output, level, secrets...)
+	logger.Now = func() time.Time {
+		return time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC)
+	}
+
+	return logger, output
+}
+
+func readLogLines(t *testing.T, output *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := make(map[string]interface{})
+		err := json.Unmarshal([]byte(line), &fields)
+		if err != nil {
+			t.Fatalf("Bad log line %s: %s", line, err.Error())
+		}
+		lines = append(lines, fields)
+	}
+
+	return lines
+}
+
+func TestLogger(t *testing.T) {
+	t.Run("Writes JSON lines with the fields", func(t *testing.T) {
+		logger, output := makeTestLogger(LevelInfo)
+
+		logger.With(Fields{"delivery_id": "guid-1"}).Info("Decision", Fields{"run_id": 1})
+
+		if output.String() != `{"delivery_id":"guid-1","level":"info","msg":"Decision","run_id":1,"time":"2020-02-29T00:00:00Z"}`+"\n" {
+			t.Errorf("Bad line: %s", output.String())
+		}
+	})
+
+	t.Run("Skips lower levels", func(t *testing.T) {
+		logger, output := makeTestLogger(LevelWarn)
+
+		logger.Debug("debug", nil)
+		logger.Info("info", nil)
+		logger.Warn("warn", nil)
+		logger.Error("error", nil)
+
+		lines := readLogLines(t, output)
+		if len(lines) != 2 || lines[0]["level"] != "warn" || lines[1]["level"] != "error" {
+			t.Errorf("Bad lines: %v", lines)
+		}
+	})
+
+	t.Run("Never writes secrets", func(t *testing.T) {
+		logger, output := makeTestLogger(LevelInfo, "ghp_secret")
+
+		logger.With(Fields{"github_token": "anything"}).Error("Request with ghp_secret failed", Fields{
+			"error":         fmt.Errorf("Bad credentials: token ghp_secret"),
+			"Authorization": "token x",
+		})
+
+		if strings.Contains(output.String(), "ghp_secret") || strings.Contains(output.String(), "anything") {
+			t.Errorf("Secret was logged: %s", output.String())
+		}
+		lines := readLogLines(t, output)
+		if lines[0]["error"] != "Bad credentials: token REDACTED" || lines[0]["Authorization"] != "REDACTED" {
+			t.Errorf("Bad line: %v", lines[0])
+		}
+	})
+
+	t.Run("Zero value", func(t *testing.T) {
+		output := &bytes.Buffer{}
+		logger := &Logger{Writer: output}
+
+		logger.Debug("plain", nil)
+		logger.With(Fields{"repo": "org/repo"}).Info("child", nil)
+
+		lines := readLogLines(t, output)
+		if len(lines) != 2 || lines[0]["level"] != "debug" || lines[1]["repo"] != "org/repo" || lines[1]["time"] == "" {
+			t.Errorf("Bad lines: %v", lines)
+		}
+	})
+
+	t.Run("With does not change the parent", func(t *testing.T) {
+		logger, output := makeTestLogger(LevelInfo)
+
+		logger.With(Fields{"repo": "org/repo"})
+		logger.Info("plain", nil)
+
+		if strings.Contains(output.String(), "org/repo") {
+			t.Errorf("Bad line: %s", output.String())
+		}
+	})
+}
+
+func TestMakeLoggerFromEnv(t *testing.T) {
+	defer os.Unsetenv("LOG_LEVEL")
+
+	logger, err := MakeLoggerFromEnv(&bytes.Buffer{})
+	if err != nil || logger.Level != LevelInfo {
+		t.Errorf("Bad logger: %v %v", logger, err)
+	}
+
+	os.Setenv("LOG_LEVEL", "warn")
+	logger, err = MakeLoggerFromEnv(&bytes.Buffer{})
+	if err != nil || logger.Level != LevelWarn {
+		t.Errorf("Bad logger: %v %v", logger, err)
+	}
+
+	os.Setenv("LOG_LEVEL", "verbose")
+	_, err = MakeLoggerFromEnv(&bytes.Buffer{})
+	if err == nil || err.Error() != "Bad log level: verbose" {
+		t.Errorf("Bad error: %v", err)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("WARN")
+	if err != nil || level != LevelWarn {
+		t.Errorf("Bad level: %s %v", level, err)
+	}
+
+	_, err = ParseLevel("verbose")
+	if err == nil || err.Error() != "Bad log level: verbose" {
+		t.Errorf("Bad error: %v", err)
+	}
+}
+
+func TestApplyDecisionsLogs(t *testing.T) {
+	logger, output := makeTestLogger(LevelInfo)
+	policy := MakeDefaultPolicy().WithLogger(logger)
+	api := &mockCancelAPI{failing: map[int64]bool{2: true}}
+
+	policy.Apply(api, []Decision{
+		Decision{Run: WorkflowRun{ID: 1, HeadBranch: "master"}, Key: "master", Reason: "newest run"},
+		Decision{Run: WorkflowRun{ID: 2, HeadBranch: "master"}, Key: "master", Cancel: true, Reason: "superseded by run 1"},
+	})
+
+	lines := readLogLines(t, output)
+	if len(lines) != 2 {
+		t.Fatalf("Bad lines: %v", lines)
+	}
+	if lines[0]["action"] != "keep" || lines[0]["run_id"] != float64(1) || lines[0]["branch"] != "master" {
+		t.Errorf("Bad line: %v", lines[0])
+	}
+	if lines[1]["action"] != "cancel failed" || lines[1]["level"] != "error" || lines[1]["reason"] != "superseded by run 1" {
+		t.Errorf("Bad line: %v", lines[1])
+	}
+}
@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const mergeQueueBranchPrefix = "gh-readonly-queue/"
+
+// Merge queue modes
+const (
+	MergeQueueSkip    = "skip"
+	MergeQueueRemoved = "removed"
+)
+
+// BranchHeadGetter is implemented by apis which can look up branches
+type BranchHeadGetter interface {
+	GetBranchHead(branch string) (string, error)
+}
+
+// PullRequestMergeChecker is implemented by apis which can tell whether a pull request was merged
+type PullRequestMergeChecker interface {
+	IsPullRequestMerged(number int64) (bool, error)
+}
+
+// isMergeQueueRun reports whether the run tests a merge queue entry
+func isMergeQueueRun(run WorkflowRun) bool {
+	return run.Event == "merge_group" || strings.HasPrefix(run.HeadBranch, mergeQueueBranchPrefix)
+}
+
+// mergeQueueEntry splits gh-readonly-queue/<base>/pr-<number>-<sha> into the base/pr-<number> key and the number
+func mergeQueueEntry(branch string) (string, string) {
+	entry := strings.TrimPrefix(branch, mergeQueueBranchPrefix)
+	index := strings.LastIndex(entry, "/pr-")
+	if index < 0 {
+		return branch, ""
+	}
+
+	number := entry[index+len("/pr-"):]
+	if dash := strings.Index(number, "-"); dash >= 0 {
+		number = number[:dash]
+	}
+
+	return mergeQueueBranchPrefix + entry[:index] + "/pr-" + number, number
+}
+
+func mergeQueueKey(run WorkflowRun) string {
+	key, _ := mergeQueueEntry(run.HeadBranch)
+	return key
+}
+
+// mergedEntry returns why the entry whose queue branch is gone may have been merged, or an empty string.
+// The queue branch is deleted when the pull request is merged as well.
+func mergedEntry(api Provider, number string) string {
+	checker, ok := api.(PullRequestMergeChecker)
+	if !ok {
+		return fmt.Sprintf("queue branch of #%s is gone, but merges can't be looked up", number)
+	}
+	id, err := strconv.ParseInt(number, 10, 64)
+	if err != nil {
+		return fmt.Sprintf("queue branch of #%s is gone, but the pull request is unknown", number)
+	}
+
+	merged, err := checker.IsPullRequestMerged(id)
+	if err != nil {
+		return fmt.Sprintf("looking up #%s failed: %s", number, err.Error())
+	}
+	if merged {
+		return fmt.Sprintf("#%s was merged", number)
+	}
+
+	return ""
+}
+
+// decideMergeQueue keeps every entry except older entries of re-queued pull requests and
+// entries whose queue branch was deleted because the pull request left the queue without being merged
+func (policy *Policy) decideMergeQueue(api Provider, runs []WorkflowRun, labels *labelCache) []Decision {
+	newestInGroup := newestRunOfNewestCommit(runs, runs, mergeQueueKey)
+	getter, canLookUp := api.(BranchHeadGetter)
+	removed := make(map[string]bool)
+	merged := make(map[string]string)
+
+	var decisions []Decision
+	for _, run := range runs {
+		key, number := mergeQueueEntry(run.HeadBranch)
+		newest := newestInGroup[key]
+
+		// Every entry has its own branch, runs of older entries of the same pull request are obsolete
+		if run.HeadBranch != newest.HeadBranch {
+			reason := policy.optOut(run, labels)
+			if reason != "" {
+				decisions = append(decisions, Decision{Run: run, Key: key, Reason: reason})
+				continue
+			}
+			decisions = append(decisions, Decision{
+				Run:    run,
+				Key:    key,
+				Cancel: true,
+				Reason: fmt.Sprintf("#%s was queued again in run %d", number, newest.ID),
+			})
+			continue
+		}
+
+		if canLookUp {
+			gone, ok := removed[run.HeadBranch]
+			if !ok {
+				_, err := getter.GetBranchHead(run.HeadBranch)
+				if err != nil && !IsNotFound(err) {
+					policy.logger().Warn("Looking up the merge queue branch failed", Fields{"branch": run.HeadBranch, "error": err})
+				}
+				gone = IsNotFound(err)
+				removed[run.HeadBranch] = gone
+			}
+			if gone && policy.optOut(run, labels) == "" {
+				reason, ok := merged[number]
+				if !ok {
+					reason = mergedEntry(api, number)
+					merged[number] = reason
+				}
+				if reason != "" {
+					decisions = append(decisions, Decision{Run: run, Key: key, Reason: reason})
+					continue
+				}
+				decisions = append(decisions, Decision{
+					Run:    run,
+					Key:    key,
+					Cancel: true,
+					Reason: fmt.Sprintf("#%s was removed from the merge queue", number),
+				})
+				continue
+			}
+		}
+
+		decisions = append(decisions, Decision{Run: run, Key: key, Reason: "entry is in the merge queue"})
+	}
+
+	return decisions
+}
@@ -0,0 +1,162 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+type mockBranchAPI struct {
+	mockLabelAPI
+	branches     map[string]string
+	branchCalls  int
+	branchErrors map[string]error
+	merged       map[int64]bool
+	mergeCalls   int
+}
+
+func (api *mockBranchAPI) IsPullRequestMerged(number int64) (bool, error) {
+	api.mergeCalls++
+	return api.merged[number], nil
+}
+
+func (api *mockBranchAPI) GetBranchHead(branch string) (string, error) {
+	api.branchCalls++
+	if err, ok := api.branchErrors[branch]; ok {
+		return "", err
+	}
+	head, ok := api.branches[branch]
+	if !ok {
+		return "", &StatusError{StatusCode: 404}
+	}
+	return head, nil
+}
+
+// mockGoneBranchAPI finds no branch and can't look up merges
+type mockGoneBranchAPI struct {
+	mockLabelAPI
+}
+
+func (api *mockGoneBranchAPI) GetBranchHead(branch string) (string, error) {
+	return "", &StatusError{StatusCode: 404}
+}
+
+func TestMergeQueueEntry(t *testing.T) {
+	key, number := mergeQueueEntry("gh-readonly-queue/release/1.0/pr-123-0123456789abcdef")
+
+	if key != "gh-readonly-queue/release/1.0/pr-123" || number != "123" {
+		t.Errorf("Bad entry: %s %s", key, number)
+	}
+}
+
+func TestDecideMergeQueue(t *testing.T) {
+	start := time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC)
+	entry := func(id int64, created time.Duration, branch, sha string) WorkflowRun {
+		return WorkflowRun{ID: id, Event: "merge_group", CreatedAt: start.Add(created), HeadBranch: branch, HeadSHA: sha, Status: "in_progress"}
+	}
+	runs := func() []WorkflowRun {
+		return []WorkflowRun{
+			entry(1, 0, "gh-readonly-queue/main/pr-1-aaa", "aaa"),
+			entry(2, time.Second, "gh-readonly-queue/main/pr-2-bbb", "bbb"),
+			entry(3, 2*time.Second, "gh-readonly-queue/main/pr-1-ccc", "ccc"),
+			entry(4, 3*time.Second, "gh-readonly-queue/main/pr-3-ddd", "ddd"),
+			entry(5, 3*time.Second, "gh-readonly-queue/main/pr-3-ddd", "ddd"),
+		}
+	}
+	reasons := func(decisions []Decision) map[int64]string {
+		reasons := make(map[int64]string)
+		for _, decision := range decisions {
+			reasons[decision.Run.ID] = decision.Reason
+			if decision.Cancel {
+				reasons[decision.Run.ID] = "cancel: " + decision.Reason
+			}
+		}
+		return reasons
+	}
+
+	t.Run("Skipped by default", func(t *testing.T) {
+		for id, reason := range reasons(MakeDefaultPolicy().Decide(nil, runs())) {
+			if reason != "merge queue runs are not deduplicated" {
+				t.Errorf("Run %d bad reason: %s", id, reason)
+			}
+		}
+	})
+
+	t.Run("Cancels entries of pull requests which left the queue", func(t *testing.T) {
+		policy := MakeDefaultPolicy()
+		policy.MergeQueue = MergeQueueRemoved
+		api := &mockBranchAPI{branches: map[string]string{
+			"gh-readonly-queue/main/pr-1-ccc": "ccc",
+			"gh-readonly-queue/main/pr-3-ddd": "ddd",
+		}}
+
+		actual := reasons(policy.Decide(api, runs()))
+
+		expected := map[int64]string{
+			1: "cancel: #1 was queued again in run 3",
+			2: "cancel: #2 was removed from the merge queue",
+			3: "entry is in the merge queue",
+			4: "entry is in the merge queue",
+			5: "entry is in the merge queue",
+		}
+		for id, reason := range expected {
+			if actual[id] != reason {
+				t.Errorf("Run %d bad reason: %s", id, actual[id])
+			}
+		}
+		if api.branchCalls != 3 {
+			t.Errorf("Every branch should be looked up once, calls: %d", api.branchCalls)
+		}
+	})
+
+	t.Run("Keeps entries of merged pull requests", func(t *testing.T) {
+		policy := MakeDefaultPolicy()
+		policy.MergeQueue = MergeQueueRemoved
+		api := &mockBranchAPI{merged: map[int64]bool{3: true}}
+
+		actual := reasons(policy.Decide(api, runs()[3:]))
+
+		if actual[4] != "#3 was merged" || actual[5] != "#3 was merged" {
+			t.Errorf("Bad reasons: %v", actual)
+		}
+		if api.mergeCalls != 1 {
+			t.Errorf("Every pull request should be looked up once, calls: %d", api.mergeCalls)
+		}
+	})
+
+	t.Run("Keeps entries when merges can't be looked up", func(t *testing.T) {
+		policy := MakeDefaultPolicy()
+		policy.MergeQueue = MergeQueueRemoved
+		api := &mockGoneBranchAPI{}
+
+		actual := reasons(policy.Decide(api, runs()[1:2]))
+
+		if actual[2] != "queue branch of #2 is gone, but merges can't be looked up" {
+			t.Errorf("Bad reason: %s", actual[2])
+		}
+	})
+
+	t.Run("Newer entries don't supersede other pull requests", func(t *testing.T) {
+		policy := MakeDefaultPolicy()
+		policy.MergeQueue = MergeQueueRemoved
+
+		for _, decision := range policy.Decide(nil, runs()) {
+			if decision.Cancel && decision.Run.ID != 1 {
+				t.Errorf("Run %d should be kept: %s", decision.Run.ID, decision.Reason)
+			}
+		}
+	})
+
+	t.Run("Lookup errors keep the entry", func(t *testing.T) {
+		policy := MakeDefaultPolicy()
+		policy.MergeQueue = MergeQueueRemoved
+		api := &mockBranchAPI{branchErrors: map[string]error{
+			"gh-readonly-queue/main/pr-2-bbb": &StatusError{StatusCode: 500},
+		}}
+
+		actual := reasons(policy.Decide(api, runs()[1:2]))
+
+		if actual[2] != "entry is in the merge queue" {
+			t.Errorf("Bad reason: %s", actual[2])
+		}
+	})
+}
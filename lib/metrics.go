@@ -0,0 +1,76 @@
+package lib
+
+import "time"
+
+// Units of the metrics
+const (
+	UnitCount        = "Count"
+	UnitMilliseconds = "Milliseconds"
+	UnitNone         = "None"
+)
+
+// Names of the metrics
+const (
+	MetricRunsCancelled         = "RunsCancelled"
+	MetricCancelFailures        = "CancelFailures"
+	MetricRunsListed            = "RunsListed"
+	MetricEstimatedMinutesSaved = "EstimatedMinutesSaved"
+	MetricGitHubAPILatency      = "GitHubAPILatency"
+	MetricGitHubAPIErrors       = "GitHubAPIErrors"
+	MetricGitHubRateLimit       = "GitHubRateLimitRemaining"
+	MetricWebhookLatency        = "WebhookLatency"
+	MetricETagHits              = "ETagHits"
+	MetricETagMisses            = "ETagMisses"
+)
+
+// Dimensions of a metric value, like Repository and Workflow
+type Dimensions map[string]string
+
+// Metrics receives the metric values, implement it to send them to another sink
+type Metrics interface {
+	Put(name string, value float64, unit string, dimensions Dimensions)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) Put(name string, value float64, unit string, dimensions Dimensions) {}
+
+// metricsOrNop returns metrics which can be used when none are configured
+func metricsOrNop(metrics Metrics) Metrics {
+	if metrics == nil {
+		return nopMetrics{}
+	}
+
+	return metrics
+}
+
+type dimensionMetrics struct {
+	metrics    Metrics
+	dimensions Dimensions
+}
+
+func (scoped dimensionMetrics) Put(name string, value float64, unit string, dimensions Dimensions) {
+	merged := make(Dimensions, len(scoped.dimensions)+len(dimensions))
+	for key, value := range scoped.dimensions {
+		merged[key] = value
+	}
+	for key, value := range dimensions {
+		merged[key] = value
+	}
+
+	scoped.metrics.Put(name, value, unit, merged)
+}
+
+// WithDimensions returns metrics adding the dimensions to every value, nil metrics stay nil
+func WithDimensions(metrics Metrics, dimensions Dimensions) Metrics {
+	if metrics == nil {
+		return nil
+	}
+
+	return dimensionMetrics{metrics: metrics, dimensions: dimensions}
+}
+
+// Milliseconds converts a duration to the value of a UnitMilliseconds metric
+func Milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,182 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/h2non/gock.v1"
+)
+
+type metricValue struct {
+	Name       string
+	Value      float64
+	Unit       string
+	Dimensions Dimensions
+}
+
+type recordingMetrics struct {
+	mu     sync.Mutex
+	values []metricValue
+}
+
+func (metrics *recordingMetrics) Put(name string, value float64, unit string, dimensions Dimensions) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	metrics.values = append(metrics.values, metricValue{Name: name, Value: value, Unit: unit, Dimensions: dimensions})
+}
+
+func (metrics *recordingMetrics) named(name string) []metricValue {
+	var values []metricValue
+	for _, value := range metrics.values {
+		if value.Name == name {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+func TestWithDimensions(t *testing.T) {
+	t.Run("Adds the dimensions to every value", func(t *testing.T) {
+		metrics := &recordingMetrics{}
+
+		WithDimensions(metrics, Dimensions{"Repository": "org/repo"}).Put(MetricRunsCancelled, 1, UnitCount, Dimensions{"Workflow": "CI"})
+
+		values := metrics.named(MetricRunsCancelled)
+		if len(values) != 1 || values[0].Dimensions["Repository"] != "org/repo" || values[0].Dimensions["Workflow"] != "CI" {
+			t.Errorf("Bad values: %v", metrics.values)
+		}
+	})
+
+	t.Run("Keeps nil metrics nil", func(t *testing.T) {
+		if WithDimensions(nil, Dimensions{"Repository": "org/repo"}) != nil {
+			t.Errorf("Bad metrics: not nil")
+		}
+	})
+}
+
+func TestApplyMetrics(t *testing.T) {
+	now := time.Date(2020, 02, 29, 12, 0, 0, 0, time.UTC)
+	policy := MakeDefaultPolicy()
+	policy.Clock = &fakeClock{now: now}
+	metrics := &recordingMetrics{}
+	policy.Metrics = metrics
+
+	err := policy.Apply(&mockCancelAPI{failing: map[int64]bool{3: true}}, []Decision{
+		Decision{Run: WorkflowRun{ID: 1, Name: "CI", Status: "queued"}, Cancel: true},
+		Decision{Run: WorkflowRun{ID: 2, Name: "CI", Status: "in_progress", RunStartedAt: now.Add(-4 * time.Minute)}, Cancel: true},
+		Decision{Run: WorkflowRun{ID: 3, Name: "Lint", Status: "in_progress"}, Cancel: true},
+		Decision{Run: WorkflowRun{ID: 4, Name: "CI", Status: "in_progress"}, Cancel: false},
+	})
+
+	if err == nil || err.Error() != "Cancelling 1 runs failed: Server error" {
+		t.Errorf("Bad error: %v", err)
+	}
+	cancelled := metrics.named(MetricRunsCancelled)
+	if len(cancelled) != 2 || cancelled[0].Dimensions["Workflow"] != "CI" || cancelled[0].Unit != UnitCount {
+		t.Errorf("Bad cancelled runs: %v", cancelled)
+	}
+	failures := metrics.named(MetricCancelFailures)
+	if len(failures) != 1 || failures[0].Dimensions["Workflow"] != "Lint" {
+		t.Errorf("Bad failures: %v", failures)
+	}
+	saved := metrics.named(MetricEstimatedMinutesSaved)
+	if len(saved) != 2 || saved[0].Value != 10 || saved[1].Value != 6 {
+		t.Errorf("Bad minutes saved: %v", saved)
+	}
+}
+
+func TestEstimateSaved(t *testing.T) {
+	now := time.Date(2020, 02, 29, 12, 0, 0, 0, time.UTC)
+	policy := MakeDefaultPolicy()
+	policy.Clock = &fakeClock{now: now}
+
+	tests := []struct {
+		name     string
+		run      WorkflowRun
+		expected time.Duration
+	}{
+		{"Queued run", WorkflowRun{Status: "queued", CreatedAt: now.Add(-time.Hour)}, 10 * time.Minute},
+		{"Started run", WorkflowRun{Status: "in_progress", RunStartedAt: now.Add(-3 * time.Minute)}, 7 * time.Minute},
+		{"Run without start time", WorkflowRun{Status: "in_progress", CreatedAt: now.Add(-8 * time.Minute)}, 2 * time.Minute},
+		{"Run longer than expected", WorkflowRun{Status: "in_progress", RunStartedAt: now.Add(-time.Hour)}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if saved := policy.estimateSaved(test.run); saved != test.expected {
+				t.Errorf("Bad estimate: %v", saved)
+			}
+		})
+	}
+}
+
+func TestGithubAPIMetrics(t *testing.T) {
+	t.Run("Measures the requests", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://api.github.com").
+			Post("/org/repo/cancel").
+			Reply(http.StatusAccepted)
+		metrics := &recordingMetrics{}
+		api := GithubAPI{Organization: "org", Repository: "repo", Metrics: metrics, Client: gockClient}
+
+		err := api.CancelRun(WorkflowRun{CancelURL: "https://api.github.com/org/repo/cancel"})
+
+		if err != nil {
+			t.Errorf("Error: %s", err.Error())
+		}
+		latency := metrics.named(MetricGitHubAPILatency)
+		if len(latency) != 1 || latency[0].Unit != UnitMilliseconds ||
+			latency[0].Dimensions["Endpoint"] != "cancel_run" || latency[0].Dimensions["Repository"] != "org/repo" ||
+			latency[0].Dimensions["Status"] != "202" {
+			t.Errorf("Bad latency: %v", latency)
+		}
+		if errors := metrics.named(MetricGitHubAPIErrors); len(errors) != 0 {
+			t.Errorf("Bad errors: %v", errors)
+		}
+	})
+
+	t.Run("Counts failed requests", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://api.github.com").
+			Get("/repos/org/repo/actions/runs").
+			Reply(http.StatusInternalServerError)
+		gock.New("https://api.github.com").
+			Post("/org/repo/cancel").
+			ReplyError(fmt.Errorf("Server error"))
+		metrics := &recordingMetrics{}
+		api := GithubAPI{Organization: "org", Repository: "repo", Metrics: metrics, Client: gockClient}
+
+		api.ListWorkflows()
+		api.CancelRun(WorkflowRun{CancelURL: "https://api.github.com/org/repo/cancel"})
+
+		errors := metrics.named(MetricGitHubAPIErrors)
+		if len(errors) != 2 || errors[0].Dimensions["Status"] != "500" || errors[1].Dimensions["Status"] != "error" {
+			t.Errorf("Bad errors: %v", errors)
+		}
+	})
+
+	t.Run("Reports the rate limit", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://api.github.com").
+			Get("/repos/org/repo/actions/runs").
+			Reply(http.StatusOK).
+			SetHeader("X-RateLimit-Remaining", "4321").
+			SetHeader("X-RateLimit-Resource", "core").
+			JSON(map[string]interface{}{"workflow_runs": []interface{}{}})
+		metrics := &recordingMetrics{}
+		api := GithubAPI{Organization: "org", Repository: "repo", Metrics: metrics, Client: gockClient}
+
+		_, err := api.ListWorkflows()
+
+		if err != nil {
+			t.Errorf("Error: %s", err.Error())
+		}
+		remaining := metrics.named(MetricGitHubRateLimit)
+		if len(remaining) != 1 || remaining[0].Value != 4321 || remaining[0].Dimensions["Resource"] != "core" {
+			t.Errorf("Bad rate limit: %v", remaining)
+		}
+	})
+}
@@ -0,0 +1,149 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Clock interface
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// RealClock is the wall clock
+var RealClock Clock = realClock{}
+
+type pollTarget struct {
+	name     string
+	api      Provider
+	next     time.Time
+	failures uint
+}
+
+// Poller periodically lists the workflows of repositories and cancels the outdated runs
+type Poller struct {
+	Interval   time.Duration
+	Jitter     time.Duration
+	MaxBackoff time.Duration
+	Clock      Clock
+	Rand       *rand.Rand
+	Policy     *Policy
+
+	targets []*pollTarget
+}
+
+// MakePoller creates a poller using the wall clock
+func MakePoller(interval, jitter, maxBackoff time.Duration) *Poller {
+	return &Poller{
+		Interval:   interval,
+		Jitter:     jitter,
+		MaxBackoff: maxBackoff,
+		Clock:      RealClock,
+		Policy:     MakeDefaultPolicy(),
+		Rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// AddRepository registers a repository to poll, the first poll happens immediately
+func (poller *Poller) AddRepository(name string, api Provider) {
+	poller.targets = append(poller.targets, &pollTarget{name: name, api: api})
+}
+
+func (poller *Poller) jitter() time.Duration {
+	if poller.Jitter <= 0 || poller.Rand == nil {
+		return 0
+	}
+
+	return time.Duration(poller.Rand.Int63n(int64(poller.Jitter)))
+}
+
+func (poller *Poller) delay(failures uint) time.Duration {
+	delay := poller.Interval
+	for i := uint(0); i < failures; i++ {
+		delay *= 2
+		if poller.MaxBackoff > 0 && delay >= poller.MaxBackoff {
+			delay = poller.MaxBackoff
+			break
+		}
+	}
+
+	return delay + poller.jitter()
+}
+
+func (poller *Poller) poll(target *pollTarget) error {
+	runs, err := target.api.ListWorkflows()
+	if err != nil {
+		return err
+	}
+
+	metrics := WithDimensions(poller.Policy.Metrics, Dimensions{"Repository": target.name})
+	metricsOrNop(metrics).Put(MetricRunsListed, float64(len(runs)), UnitCount, nil)
+	policy := poller.Policy.WithLogger(poller.Policy.logger().With(Fields{"repo": target.name})).WithMetrics(metrics)
+	return policy.AutomaticCancel(target.api, runs)
+}
+
+func (poller *Poller) pollDue(ctx context.Context, now time.Time) {
+	for _, target := range poller.targets {
+		if ctx.Err() != nil {
+			return
+		}
+		if target.next.After(now) {
+			continue
+		}
+
+		err := poller.poll(target)
+		if err != nil {
+			target.failures++
+			poller.Policy.logger().Warn("Polling failed", Fields{"repo": target.name, "failures": target.failures, "error": err})
+		} else {
+			target.failures = 0
+		}
+		target.next = now.Add(poller.delay(target.failures))
+	}
+}
+
+func (poller *Poller) nextWakeup() time.Time {
+	next := poller.targets[0].next
+	for _, target := range poller.targets[1:] {
+		if target.next.Before(next) {
+			next = target.next
+		}
+	}
+
+	return next
+}
+
+// Run polls the repositories until the context is cancelled
+func (poller *Poller) Run(ctx context.Context) error {
+	if len(poller.targets) == 0 {
+		return fmt.Errorf("No repositories to poll")
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		poller.pollDue(ctx, poller.Clock.Now())
+
+		wait := poller.nextWakeup().Sub(poller.Clock.Now())
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-poller.Clock.After(wait):
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now   time.Time
+	waits []time.Duration
+}
+
+func (clock *fakeClock) Now() time.Time {
+	return clock.now
+}
+
+// After advances the clock immediately so the poll loop never blocks
+func (clock *fakeClock) After(d time.Duration) <-chan time.Time {
+	clock.waits = append(clock.waits, d)
+	clock.now = clock.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- clock.now
+	return ch
+}
+
+type mockPollAPI struct {
+	listErrors []error
+	listTimes  []time.Time
+	cancels    []WorkflowRun
+	clock      *fakeClock
+	onList     func(calls int)
+}
+
+func (api *mockPollAPI) ListWorkflows() ([]WorkflowRun, error) {
+	api.listTimes = append(api.listTimes, api.clock.Now())
+	calls := len(api.listTimes)
+	if api.onList != nil {
+		api.onList(calls)
+	}
+	if calls <= len(api.listErrors) && api.listErrors[calls-1] != nil {
+		return nil, api.listErrors[calls-1]
+	}
+
+	return []WorkflowRun{
+		WorkflowRun{ID: 1, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC), HeadBranch: "master", Status: "running"},
+		WorkflowRun{ID: 2, CreatedAt: time.Date(2020, 02, 29, 0, 0, 0, 1, time.UTC), HeadBranch: "master", Status: "running"},
+	}, nil
+}
+
+func (api *mockPollAPI) CancelRun(run WorkflowRun) error {
+	api.cancels = append(api.cancels, run)
+	return nil
+}
+
+func (api *mockPollAPI) GroupKey(run WorkflowRun) string {
+	return run.HeadBranch
+}
+
+func makeTestPoller(clock *fakeClock) *Poller {
+	return &Poller{
+		Interval:   time.Minute,
+		MaxBackoff: 4 * time.Minute,
+		Clock:      clock,
+		Policy:     MakeDefaultPolicy(),
+	}
+}
+
+func TestPollerRun(t *testing.T) {
+	start := time.Date(2020, 02, 29, 0, 0, 0, 0, time.UTC)
+
+	t.Run("Run without repositories", func(t *testing.T) {
+		poller := makeTestPoller(&fakeClock{now: start})
+
+		err := poller.Run(context.Background())
+		if err == nil {
+			t.Errorf("Missing error")
+		}
+	})
+
+	t.Run("Polls on interval and cancels outdated runs", func(t *testing.T) {
+		clock := &fakeClock{now: start}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		api := &mockPollAPI{clock: clock, onList: func(calls int) {
+			if calls == 3 {
+				cancel()
+			}
+		}}
+		poller := makeTestPoller(clock)
+		poller.AddRepository("org/repo", api)
+
+		err := poller.Run(ctx)
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+
+		expectedTimes := []time.Time{start, start.Add(time.Minute), start.Add(2 * time.Minute)}
+		if len(api.listTimes) != len(expectedTimes) {
+			t.Fatalf("Expected list count %d actual %d", len(expectedTimes), len(api.listTimes))
+		}
+		for i, listTime := range api.listTimes {
+			if !listTime.Equal(expectedTimes[i]) {
+				t.Errorf("Expected list at %s actual %s", expectedTimes[i], listTime)
+			}
+		}
+		if len(api.cancels) != 3 || api.cancels[0].ID != 1 {
+			t.Errorf("Expected run 1 cancelled on every poll, cancels: %v", api.cancels)
+		}
+	})
+
+	t.Run("Backs off per repository on errors", func(t *testing.T) {
+		clock := &fakeClock{now: start}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		serverError := fmt.Errorf("Server error")
+		failing := &mockPollAPI{
+			clock:      clock,
+			listErrors: []error{serverError, serverError, serverError, serverError, nil},
+			onList: func(calls int) {
+				if calls == 6 {
+					cancel()
+				}
+			},
+		}
+		healthy := &mockPollAPI{clock: clock}
+		poller := makeTestPoller(clock)
+		poller.AddRepository("org/failing", failing)
+		poller.AddRepository("org/healthy", healthy)
+
+		poller.Run(ctx)
+
+		// 2, 4, 4 (capped), 4 minutes of backoff, then back to the interval
+		expectedTimes := []time.Time{
+			start,
+			start.Add(2 * time.Minute),
+			start.Add(6 * time.Minute),
+			start.Add(10 * time.Minute),
+			start.Add(14 * time.Minute),
+			start.Add(15 * time.Minute),
+		}
+		if len(failing.listTimes) != len(expectedTimes) {
+			t.Fatalf("Expected list count %d actual %d", len(expectedTimes), len(failing.listTimes))
+		}
+		for i, listTime := range failing.listTimes {
+			if !listTime.Equal(expectedTimes[i]) {
+				t.Errorf("Expected list at %s actual %s", expectedTimes[i], listTime)
+			}
+		}
+		if len(healthy.listTimes) < 15 {
+			t.Errorf("Healthy repository should be polled every minute, polled %d times", len(healthy.listTimes))
+		}
+	})
+
+	t.Run("Adds jitter to the interval", func(t *testing.T) {
+		clock := &fakeClock{now: start}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		api := &mockPollAPI{clock: clock, onList: func(calls int) {
+			if calls == 5 {
+				cancel()
+			}
+		}}
+		poller := makeTestPoller(clock)
+		poller.Jitter = 10 * time.Second
+		poller.Rand = MakePoller(0, 0, 0).Rand
+		poller.AddRepository("org/repo", api)
+
+		poller.Run(ctx)
+
+		for _, wait := range clock.waits {
+			if wait < time.Minute || wait >= time.Minute+10*time.Second {
+				t.Errorf("Wait out of jitter range: %s", wait)
+			}
+		}
+	})
+}
@@ -0,0 +1,191 @@
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultPrometheusBuckets are the upper bounds in seconds of the request duration histograms
+var DefaultPrometheusBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+const (
+	prometheusCounter   = "counter"
+	prometheusGauge     = "gauge"
+	prometheusHistogram = "histogram"
+)
+
+type prometheusFamily struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	series map[string]*prometheusSeries
+}
+
+type prometheusSeries struct {
+	labels []string
+	value  float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// PrometheusMetrics keeps the values in memory and serves them in the Prometheus text format
+type PrometheusMetrics struct {
+	Buckets []float64
+
+	mu       sync.Mutex
+	families map[string]*prometheusFamily
+}
+
+// MakePrometheusMetrics creates the sink with the default buckets
+func MakePrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{Buckets: DefaultPrometheusBuckets}
+}
+
+// Put maps the value to the Prometheus families, values without a family are dropped
+func (prom *PrometheusMetrics) Put(name string, value float64, unit string, dimensions Dimensions) {
+	switch name {
+	case MetricWebhookLatency:
+		prom.add(prometheusCounter, "actions_cancel_webhooks_total", "Webhook deliveries by event and outcome.",
+			[]string{"event", "outcome"}, []string{dimensions["Event"], dimensions["Outcome"]}, 1)
+	case MetricRunsCancelled, MetricCancelFailures:
+		result := "cancelled"
+		if name == MetricCancelFailures {
+			result = "failed"
+		}
+		prom.add(prometheusCounter, "actions_cancel_cancels_total", "Cancel attempts by repository, workflow and result.",
+			[]string{"repo", "workflow", "result"}, []string{dimensions["Repository"], dimensions["Workflow"], result}, value)
+	case MetricGitHubAPILatency:
+		seconds := value
+		if unit == UnitMilliseconds {
+			seconds = value / 1000
+		}
+		prom.observe("actions_cancel_github_request_duration_seconds", "GitHub API requests by endpoint and status.",
+			[]string{"endpoint", "status"}, []string{dimensions["Endpoint"], dimensions["Status"]}, seconds)
+	case MetricETagHits, MetricETagMisses:
+		result := "hit"
+		if name == MetricETagMisses {
+			result = "miss"
+		}
+		prom.add(prometheusCounter, "actions_cancel_etag_requests_total", "Run listings by repository and ETag cache result.",
+			[]string{"repo", "result"}, []string{dimensions["Repository"], result}, value)
+	case MetricGitHubRateLimit:
+		prom.set("actions_cancel_github_rate_limit_remaining", "Requests left from the GitHub rate limit.",
+			[]string{"repo", "resource"}, []string{dimensions["Repository"], dimensions["Resource"]}, value)
+	}
+}
+
+func (prom *PrometheusMetrics) seriesOf(kind, name, help string, labels, values []string) *prometheusSeries {
+	if prom.families == nil {
+		prom.families = make(map[string]*prometheusFamily)
+	}
+	family, ok := prom.families[name]
+	if !ok {
+		family = &prometheusFamily{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*prometheusSeries)}
+		prom.families[name] = family
+	}
+
+	key := strings.Join(values, "\xff")
+	series, ok := family.series[key]
+	if !ok {
+		series = &prometheusSeries{labels: values}
+		if kind == prometheusHistogram {
+			series.counts = make([]uint64, len(prom.Buckets))
+		}
+		family.series[key] = series
+	}
+
+	return series
+}
+
+func (prom *PrometheusMetrics) add(kind, name, help string, labels, values []string, value float64) {
+	prom.mu.Lock()
+	defer prom.mu.Unlock()
+	prom.seriesOf(kind, name, help, labels, values).value += value
+}
+
+func (prom *PrometheusMetrics) set(name, help string, labels, values []string, value float64) {
+	prom.mu.Lock()
+	defer prom.mu.Unlock()
+	prom.seriesOf(prometheusGauge, name, help, labels, values).value = value
+}
+
+func (prom *PrometheusMetrics) observe(name, help string, labels, values []string, value float64) {
+	prom.mu.Lock()
+	defer prom.mu.Unlock()
+	series := prom.seriesOf(prometheusHistogram, name, help, labels, values)
+	for i, bound := range prom.Buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.sum += value
+	series.count++
+}
+
+// ServeHTTP writes every family in the text exposition format
+func (prom *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	prom.mu.Lock()
+	defer prom.mu.Unlock()
+	names := make([]string, 0, len(prom.families))
+	for name := range prom.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		family := prom.families[name]
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", family.name, family.help, family.name, family.kind)
+		keys := make([]string, 0, len(family.series))
+		for key := range family.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			series := family.series[key]
+			if family.kind != prometheusHistogram {
+				fmt.Fprintf(out, "%s%s %s\n", family.name, formatLabels(family.labels, series.labels, ""), formatFloat(series.value))
+				continue
+			}
+			for i, bound := range prom.Buckets {
+				fmt.Fprintf(out, "%s_bucket%s %d\n", family.name, formatLabels(family.labels, series.labels, formatFloat(bound)), series.counts[i])
+			}
+			fmt.Fprintf(out, "%s_bucket%s %d\n", family.name, formatLabels(family.labels, series.labels, "+Inf"), series.count)
+			fmt.Fprintf(out, "%s_sum%s %s\n", family.name, formatLabels(family.labels, series.labels, ""), formatFloat(series.sum))
+			fmt.Fprintf(out, "%s_count%s %d\n", family.name, formatLabels(family.labels, series.labels, ""), series.count)
+		}
+	}
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels writes the label set, le is added for histogram buckets when not empty
+func formatLabels(names, values []string, le string) string {
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+labelValueReplacer.Replace(values[i])+`"`)
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,91 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T, metrics *PrometheusMetrics) string {
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("Bad response: %d %s", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+
+	return recorder.Body.String()
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	t.Run("Counts webhooks and cancels", func(t *testing.T) {
+		metrics := MakePrometheusMetrics()
+
+		metrics.Put(MetricWebhookLatency, 12, UnitMilliseconds, Dimensions{"Event": "push", "Outcome": "handled"})
+		metrics.Put(MetricWebhookLatency, 15, UnitMilliseconds, Dimensions{"Event": "push", "Outcome": "handled"})
+		metrics.Put(MetricRunsCancelled, 1, UnitCount, Dimensions{"Repository": "org/repo", "Workflow": "CI"})
+		metrics.Put(MetricCancelFailures, 1, UnitCount, Dimensions{"Repository": "org/repo", "Workflow": `Lint "fast"`})
+		metrics.Put(MetricRunsListed, 3, UnitCount, Dimensions{"Repository": "org/repo"})
+
+		expected := `# HELP actions_cancel_cancels_total Cancel attempts by repository, workflow and result.
+# TYPE actions_cancel_cancels_total counter
+actions_cancel_cancels_total{repo="org/repo",workflow="CI",result="cancelled"} 1
+actions_cancel_cancels_total{repo="org/repo",workflow="Lint \"fast\"",result="failed"} 1
+# HELP actions_cancel_webhooks_total Webhook deliveries by event and outcome.
+# TYPE actions_cancel_webhooks_total counter
+actions_cancel_webhooks_total{event="push",outcome="handled"} 2
+`
+		if body := scrape(t, metrics); body != expected {
+			t.Errorf("Bad exposition: %s", body)
+		}
+	})
+
+	t.Run("Counts ETag hits and misses", func(t *testing.T) {
+		metrics := MakePrometheusMetrics()
+
+		metrics.Put(MetricETagHits, 1, UnitCount, Dimensions{"Repository": "org/repo"})
+		metrics.Put(MetricETagHits, 1, UnitCount, Dimensions{"Repository": "org/repo"})
+		metrics.Put(MetricETagMisses, 1, UnitCount, Dimensions{"Repository": "org/repo"})
+
+		expected := `# HELP actions_cancel_etag_requests_total Run listings by repository and ETag cache result.
+# TYPE actions_cancel_etag_requests_total counter
+actions_cancel_etag_requests_total{repo="org/repo",result="hit"} 2
+actions_cancel_etag_requests_total{repo="org/repo",result="miss"} 1
+`
+		if body := scrape(t, metrics); body != expected {
+			t.Errorf("Bad exposition: %s", body)
+		}
+	})
+
+	t.Run("Observes request durations", func(t *testing.T) {
+		metrics := MakePrometheusMetrics()
+		metrics.Buckets = []float64{0.1, 1}
+
+		metrics.Put(MetricGitHubAPILatency, 50, UnitMilliseconds, Dimensions{"Endpoint": "list_runs", "Status": "200"})
+		metrics.Put(MetricGitHubAPILatency, 500, UnitMilliseconds, Dimensions{"Endpoint": "list_runs", "Status": "200"})
+		metrics.Put(MetricGitHubAPILatency, 2000, UnitMilliseconds, Dimensions{"Endpoint": "list_runs", "Status": "200"})
+
+		expected := `# HELP actions_cancel_github_request_duration_seconds GitHub API requests by endpoint and status.
+# TYPE actions_cancel_github_request_duration_seconds histogram
+actions_cancel_github_request_duration_seconds_bucket{endpoint="list_runs",status="200",le="0.1"} 1
+actions_cancel_github_request_duration_seconds_bucket{endpoint="list_runs",status="200",le="1"} 2
+actions_cancel_github_request_duration_seconds_bucket{endpoint="list_runs",status="200",le="+Inf"} 3
+actions_cancel_github_request_duration_seconds_sum{endpoint="list_runs",status="200"} 2.55
+actions_cancel_github_request_duration_seconds_count{endpoint="list_runs",status="200"} 3
+`
+		if body := scrape(t, metrics); body != expected {
+			t.Errorf("Bad exposition: %s", body)
+		}
+	})
+
+	t.Run("Keeps the last rate limit", func(t *testing.T) {
+		metrics := MakePrometheusMetrics()
+
+		metrics.Put(MetricGitHubRateLimit, 4999, UnitCount, Dimensions{"Repository": "org/repo", "Resource": "core"})
+		metrics.Put(MetricGitHubRateLimit, 4998, UnitCount, Dimensions{"Repository": "org/repo", "Resource": "core"})
+
+		if body := scrape(t, metrics); !strings.Contains(body, `actions_cancel_github_rate_limit_remaining{repo="org/repo",resource="core"} 4998`+"\n") {
+			t.Errorf("Bad exposition: %s", body)
+		}
+	})
+}
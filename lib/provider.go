@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+)
+
+// Providers configured by ProviderNameFromEnv
+const (
+	ProviderGitHub = "github"
+	ProviderGitea  = "gitea"
+	ProviderGitLab = "gitlab"
+)
+
+// Provider is a CI system whose active runs can be listed and cancelled, like GitHub Actions or GitLab CI.
+// Only the runs of the newest commit of a group are kept, GitHub groups the runs by branch.
+// Lookups only some providers support, like listing jobs, are optional interfaces.
+type Provider interface {
+	ListWorkflows() ([]WorkflowRun, error)
+	CancelRun(run WorkflowRun) error
+	GroupKey(run WorkflowRun) string
+}
+
+func branchKey(run WorkflowRun) string {
+	return run.HeadBranch
+}
+
+// groupKeyOf returns how the provider groups its runs, runs are grouped by branch without a provider
+func groupKeyOf(api Provider) func(WorkflowRun) string {
+	if api == nil {
+		return branchKey
+	}
+
+	return api.GroupKey
+}
+
+// ProviderNameFromEnv returns gitlab when GITLAB_URL is set, gitea when GITEA_URL is set and github otherwise
+func ProviderNameFromEnv() string {
+	if os.Getenv("GITLAB_URL") != "" {
+		return ProviderGitLab
+	}
+	if os.Getenv("GITEA_URL") != "" {
+		return ProviderGitea
+	}
+
+	return ProviderGitHub
+}
+
+// RepositoryFromEnv returns the repository of the handler, GITLAB_PROJECT for GitLab and GITHUB_ORG/GITHUB_REPO otherwise
+func RepositoryFromEnv() string {
+	if ProviderNameFromEnv() == ProviderGitLab {
+		return os.Getenv("GITLAB_PROJECT")
+	}
+
+	return os.Getenv("GITHUB_ORG") + "/" + os.Getenv("GITHUB_REPO")
+}
+
+// ProviderFromEnv returns the provider of the repository: the project with the group/name path on GitLab,
+// the org/name repository on Gitea or GitHub. The etags and metrics are only used by GitHub.
+func ProviderFromEnv(repository string, etags *ETagCache, metrics Metrics) (Provider, error) {
+	if ProviderNameFromEnv() == ProviderGitLab {
+		token := os.Getenv("GITLAB_TOKEN")
+		if token == "" {
+			return nil, fmt.Errorf("Missing GITLAB_TOKEN")
+		}
+		if repository == "" {
+			return nil, fmt.Errorf("Missing GITLAB_PROJECT")
+		}
+		return MakeGitLabAPI(os.Getenv("GITLAB_URL"), repository, token), nil
+	}
+
+	api, err := MakeGithubAPIFor(repository)
+	if err != nil {
+		return nil, err
+	}
+	api.ETags = etags
+	api.Metrics = metrics
+	return APIFromEnv(api)
+}
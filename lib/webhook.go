@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const branchRefPrefix = "refs/heads/"
+
+// WebhookPayload holds the fields of the webhook payloads the canceler uses
+type WebhookPayload struct {
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	Action      string `json:"action"`
+	Ref         string `json:"ref"`
+	RefType     string `json:"ref_type"`
+	Deleted     bool   `json:"deleted"`
+	After       string `json:"after"`
+	PullRequest *struct {
+		Number int64 `json:"number"`
+		Merged bool  `json:"merged"`
+		Head   struct {
+			Ref  string      `json:"ref"`
+			SHA  string      `json:"sha"`
+			Repo *Repository `json:"repo"`
+		} `json:"head"`
+	} `json:"pull_request"`
+	WorkflowRun *struct {
+		HeadSHA string `json:"head_sha"`
+	} `json:"workflow_run"`
+}
+
+// ParseWebhookPayload parses the body of a webhook delivery
+func ParseWebhookPayload(body string) (WebhookPayload, error) {
+	payload := WebhookPayload{}
+	err := json.Unmarshal([]byte(body), &payload)
+	return payload, err
+}
+
+// HeadSHA returns the commit the event is about
+func (payload WebhookPayload) HeadSHA() string {
+	if payload.WorkflowRun != nil && payload.WorkflowRun.HeadSHA != "" {
+		return payload.WorkflowRun.HeadSHA
+	}
+	if payload.PullRequest != nil && payload.PullRequest.Head.SHA != "" {
+		return payload.PullRequest.Head.SHA
+	}
+
+	return payload.After
+}
+
+// ClosedPullRequest returns the pull request of a pull_request closed event
+func (payload WebhookPayload) ClosedPullRequest(event string) (int64, string, bool) {
+	if event != "pull_request" || payload.Action != "closed" || payload.PullRequest == nil {
+		return 0, "", false
+	}
+
+	return payload.PullRequest.Number, payload.PullRequest.Head.Ref, true
+}
+
+// PullRequestHeadRepository returns the repository the pull request's branch lives in, which differs for forks
+func (payload WebhookPayload) PullRequestHeadRepository() string {
+	if payload.PullRequest == nil || payload.PullRequest.Head.Repo == nil {
+		return payload.Repository.FullName
+	}
+
+	return payload.PullRequest.Head.Repo.FullName
+}
+
+// DeletedBranch returns the branch removed by a delete event or a push deleting it
+func (payload WebhookPayload) DeletedBranch(event string) (string, bool) {
+	switch {
+	case event == "delete" && payload.RefType == "branch":
+		return payload.Ref, true
+	case event == "push" && payload.Deleted && strings.HasPrefix(payload.Ref, branchRefPrefix):
+		return strings.TrimPrefix(payload.Ref, branchRefPrefix), true
+	}
+
+	return "", false
+}
@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"testing"
+)
+
+func TestWebhookPayloadHeadSHA(t *testing.T) {
+	t.Run("Push", func(t *testing.T) {
+		payload, err := ParseWebhookPayload(`{"after":"abc","repository":{"full_name":"org/repo"}}`)
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		if payload.HeadSHA() != "abc" || payload.Repository.FullName != "org/repo" {
+			t.Errorf("Bad payload: %+v", payload)
+		}
+	})
+
+	t.Run("Pull request", func(t *testing.T) {
+		payload, _ := ParseWebhookPayload(`{"pull_request":{"head":{"sha":"def"}}}`)
+		if payload.HeadSHA() != "def" {
+			t.Errorf("Bad head sha: %s", payload.HeadSHA())
+		}
+	})
+
+	t.Run("Workflow run", func(t *testing.T) {
+		payload, _ := ParseWebhookPayload(`{"workflow_run":{"head_sha":"123"}}`)
+		if payload.HeadSHA() != "123" {
+			t.Errorf("Bad head sha: %s", payload.HeadSHA())
+		}
+	})
+}
+
+func TestWebhookPayloadClosedPullRequest(t *testing.T) {
+	body := `{"action":"closed","pull_request":{"number":7,"merged":true,"head":{"ref":"feature","sha":"abc"}}}`
+	payload, _ := ParseWebhookPayload(body)
+
+	number, branch, ok := payload.ClosedPullRequest("pull_request")
+	if !ok || number != 7 || branch != "feature" {
+		t.Errorf("Bad closed pull request: %d %s %t", number, branch, ok)
+	}
+
+	_, _, ok = payload.ClosedPullRequest("push")
+	if ok {
+		t.Errorf("Push should not close a pull request")
+	}
+
+	payload.Action = "synchronize"
+	_, _, ok = payload.ClosedPullRequest("pull_request")
+	if ok {
+		t.Errorf("Synchronize should not close a pull request")
+	}
+}
+
+func TestWebhookPayloadDeletedBranch(t *testing.T) {
+	t.Run("Delete event", func(t *testing.T) {
+		payload, _ := ParseWebhookPayload(`{"ref":"feature","ref_type":"branch"}`)
+
+		branch, ok := payload.DeletedBranch("delete")
+		if !ok || branch != "feature" {
+			t.Errorf("Bad deleted branch: %s %t", branch, ok)
+		}
+	})
+
+	t.Run("Deleted tag", func(t *testing.T) {
+		payload, _ := ParseWebhookPayload(`{"ref":"v1.0","ref_type":"tag"}`)
+
+		_, ok := payload.DeletedBranch("delete")
+		if ok {
+			t.Errorf("Tag should not be a deleted branch")
+		}
+	})
+
+	t.Run("Push deleting the branch", func(t *testing.T) {
+		payload, _ := ParseWebhookPayload(`{"ref":"refs/heads/feature/x","deleted":true}`)
+
+		branch, ok := payload.DeletedBranch("push")
+		if !ok || branch != "feature/x" {
+			t.Errorf("Bad deleted branch: %s %t", branch, ok)
+		}
+	})
+
+	t.Run("Push", func(t *testing.T) {
+		payload, _ := ParseWebhookPayload(`{"ref":"refs/heads/feature","after":"abc"}`)
+
+		_, ok := payload.DeletedBranch("push")
+		if ok {
+			t.Errorf("Push should not delete the branch")
+		}
+	})
+}
@@ -3,6 +3,8 @@ package utils
 import (
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
 	"fmt"
 	"strings"
@@ -10,9 +12,22 @@ import (
 	"github.com/aws/aws-lambda-go/events"
 )
 
+// VerifyWebhookRequest validate a delivery the way the provider signs it: gitea for Gitea and Forgejo,
+// gitlab for GitLab and github otherwise, deliveries signed for another provider are rejected
+func VerifyWebhookRequest(req events.APIGatewayProxyRequest, secret, provider string) error {
+	switch provider {
+	case "gitea":
+		return VerifyGiteaWebhookRequest(req, secret)
+	case "gitlab":
+		return VerifyGitlabWebhookRequest(req, secret)
+	}
+
+	return VerifyGithubWebhookRequest(req, secret)
+}
+
 // VerifyGithubWebhookRequest validate X-Hub-Signature
 func VerifyGithubWebhookRequest(req events.APIGatewayProxyRequest, secret string) error {
-	xHubSignature, ok := req.Headers["X-Hub-Signature"]
+	xHubSignature, ok := GetHeader(req.Headers, "X-Hub-Signature")
 	if !ok {
 		return fmt.Errorf("Missing signature")
 	}
@@ -31,9 +46,75 @@ func VerifyGithubWebhookRequest(req events.APIGatewayProxyRequest, secret string
 	return nil
 }
 
-func verifyPayload(secret string, payload, signature []byte) bool {
+// VerifyGiteaWebhookRequest validate the hex SHA-256 HMAC of X-Gitea-Signature, Forgejo also sends it as X-Forgejo-Signature
+func VerifyGiteaWebhookRequest(req events.APIGatewayProxyRequest, secret string) error {
+	giteaSignature, ok := GetHeader(req.Headers, "X-Gitea-Signature")
+	if !ok {
+		giteaSignature, ok = GetHeader(req.Headers, "X-Forgejo-Signature")
+	}
+	if !ok {
+		return fmt.Errorf("Missing signature")
+	}
+	signature, err := hex.DecodeString(giteaSignature)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(signature, computeSHA256MAC(secret, []byte(req.Body))) {
+		return fmt.Errorf("Signature missmatch")
+	}
+
+	return nil
+}
+
+// VerifyGitlabWebhookRequest validate X-Gitlab-Token, GitLab sends the secret token itself instead of a signature
+func VerifyGitlabWebhookRequest(req events.APIGatewayProxyRequest, secret string) error {
+	token, ok := GetHeader(req.Headers, "X-Gitlab-Token")
+	if !ok {
+		return fmt.Errorf("Missing signature")
+	}
+	if secret == "" || subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+		return fmt.Errorf("Token missmatch")
+	}
+
+	return nil
+}
+
+// GetHeader looks up a header case-insensitively, HTTP API and function URL payloads use lowercase names
+func GetHeader(headers map[string]string, name string) (string, bool) {
+	if value, ok := headers[name]; ok {
+		return value, true
+	}
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+// SignPayload returns the X-Hub-Signature header value of the payload
+func SignPayload(secret string, payload []byte) string {
+	return "sha1=" + hex.EncodeToString(computeMAC(secret, payload))
+}
+
+// SignPayloadSHA256 returns the X-Gitea-Signature header value of the payload
+func SignPayloadSHA256(secret string, payload []byte) string {
+	return hex.EncodeToString(computeSHA256MAC(secret, payload))
+}
+
+func computeSHA256MAC(secret string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func computeMAC(secret string, payload []byte) []byte {
 	mac := hmac.New(sha1.New, []byte(secret))
 	mac.Write(payload)
-	expectedMAC := mac.Sum(nil)
-	return hmac.Equal(signature, expectedMAC)
+	return mac.Sum(nil)
+}
+
+func verifyPayload(secret string, payload, signature []byte) bool {
+	return hmac.Equal(signature, computeMAC(secret, payload))
 }
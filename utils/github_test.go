@@ -73,3 +73,146 @@ func TestVerifyGithubWebhookRequest(t *testing.T) {
 		}
 	})
 }
+
+func TestGetHeader(t *testing.T) {
+	t.Run("Exact match", func(t *testing.T) {
+		value, ok := GetHeader(map[string]string{"X-Hub-Signature": "sha1=abc"}, "X-Hub-Signature")
+
+		if !ok || value != "sha1=abc" {
+			t.Errorf("Bad header value %s", value)
+		}
+	})
+
+	t.Run("Lowercase header", func(t *testing.T) {
+		value, ok := GetHeader(map[string]string{"x-hub-signature": "sha1=abc"}, "X-Hub-Signature")
+
+		if !ok || value != "sha1=abc" {
+			t.Errorf("Bad header value %s", value)
+		}
+	})
+
+	t.Run("Missing header", func(t *testing.T) {
+		_, ok := GetHeader(map[string]string{}, "X-Hub-Signature")
+
+		if ok {
+			t.Errorf("Header should be missing")
+		}
+	})
+}
+
+func TestSignPayload(t *testing.T) {
+	signature := SignPayload("secret", []byte("dummy"))
+
+	if signature != "sha1=2486c8590c396f876a46fb541e57fb3f9f276052" {
+		t.Errorf("Bad signature %s", signature)
+	}
+}
+
+func TestVerifyGiteaWebhookRequest(t *testing.T) {
+	t.Run("Valid signature", func(t *testing.T) {
+		err := VerifyWebhookRequest(events.APIGatewayProxyRequest{
+			Body: "dummy",
+			Headers: map[string]string{
+				"X-Gitea-Signature": "c707510f6b6d47e4fa694c38d18a82451114209b1cc3b21d7aee93a277539aca",
+			}}, "secret", "gitea")
+
+		if err != nil {
+			t.Errorf("Should not return error %s", err.Error())
+		}
+	})
+
+	t.Run("Forgejo header", func(t *testing.T) {
+		err := VerifyWebhookRequest(events.APIGatewayProxyRequest{
+			Body:    "dummy",
+			Headers: map[string]string{"x-forgejo-signature": SignPayloadSHA256("secret", []byte("dummy"))},
+		}, "secret", "gitea")
+
+		if err != nil {
+			t.Errorf("Should not return error %s", err.Error())
+		}
+	})
+
+	t.Run("Signature missmatch", func(t *testing.T) {
+		err := VerifyWebhookRequest(events.APIGatewayProxyRequest{
+			Body:    "other",
+			Headers: map[string]string{"X-Gitea-Signature": SignPayloadSHA256("secret", []byte("dummy"))},
+		}, "secret", "gitea")
+
+		if err == nil || err.Error() != "Signature missmatch" {
+			t.Errorf("Bad error %v", err)
+		}
+	})
+
+	t.Run("Signature decode err", func(t *testing.T) {
+		err := VerifyWebhookRequest(events.APIGatewayProxyRequest{
+			Headers: map[string]string{"X-Gitea-Signature": "badsign"},
+		}, "secret", "gitea")
+
+		if err == nil {
+			t.Errorf("Missing error")
+		}
+	})
+}
+
+func TestVerifyGitlabWebhookRequest(t *testing.T) {
+	t.Run("Valid token", func(t *testing.T) {
+		err := VerifyWebhookRequest(events.APIGatewayProxyRequest{
+			Headers: map[string]string{"X-Gitlab-Token": "secret"},
+		}, "secret", "gitlab")
+
+		if err != nil {
+			t.Errorf("Should not return error %s", err.Error())
+		}
+	})
+
+	t.Run("Token missmatch", func(t *testing.T) {
+		err := VerifyWebhookRequest(events.APIGatewayProxyRequest{
+			Headers: map[string]string{"x-gitlab-token": "other"},
+		}, "secret", "gitlab")
+
+		if err == nil || err.Error() != "Token missmatch" {
+			t.Errorf("Bad error %v", err)
+		}
+	})
+
+	t.Run("Empty secret", func(t *testing.T) {
+		err := VerifyWebhookRequest(events.APIGatewayProxyRequest{
+			Headers: map[string]string{"X-Gitlab-Token": ""},
+		}, "", "gitlab")
+
+		if err == nil {
+			t.Errorf("Missing error")
+		}
+	})
+}
+
+func TestVerifyWebhookRequest(t *testing.T) {
+	t.Run("Missing signature", func(t *testing.T) {
+		err := VerifyWebhookRequest(events.APIGatewayProxyRequest{}, "secret", "github")
+
+		if err == nil || err.Error() != "Missing signature" {
+			t.Errorf("Bad error %v", err)
+		}
+	})
+
+	t.Run("GitHub rejects GitLab tokens", func(t *testing.T) {
+		err := VerifyWebhookRequest(events.APIGatewayProxyRequest{
+			Headers: map[string]string{"X-Gitlab-Token": "secret"},
+		}, "secret", "github")
+
+		if err == nil || err.Error() != "Missing signature" {
+			t.Errorf("Bad error %v", err)
+		}
+	})
+
+	t.Run("GitHub rejects Gitea signatures", func(t *testing.T) {
+		err := VerifyWebhookRequest(events.APIGatewayProxyRequest{
+			Body:    "dummy",
+			Headers: map[string]string{"X-Gitea-Signature": SignPayloadSHA256("secret", []byte("dummy"))},
+		}, "secret", "")
+
+		if err == nil || err.Error() != "Missing signature" {
+			t.Errorf("Bad error %v", err)
+		}
+	})
+}